@@ -3,23 +3,576 @@
  * This stub enables TypeScript imports during the migration.
  */
 
+/** Loudness measurements in loudnorm's own parameter names */
+export interface MeasuredLoudness {
+  measured_I: number;
+  measured_TP: number;
+  measured_LRA: number;
+  measured_thresh: number;
+  offset: number;
+}
+
+/** Noise gate settings for the agate filter */
+export interface GateOptions {
+  /** Level below which the gate closes, linear 0-1 (default 0.02) */
+  threshold?: number;
+  /** Attenuation ratio applied below the threshold (default 4) */
+  ratio?: number;
+  /** Attack time in ms (default 10) */
+  attack?: number;
+  /** Release time in ms (default 250) */
+  release?: number;
+}
+
+/** Room simulation settings for the aecho-based reverb */
+export interface ReverbOptions {
+  /** Simulated room size (default small) */
+  room?: 'small' | 'medium' | 'hall';
+  /** Level of the reflections against the dry signal, 0-1 (default 0.3) */
+  wet?: number;
+  /** How quickly successive reflections fade, 0-1 exclusive (default 0.5) */
+  decay?: number;
+}
+
+/**
+ * Dynamics expansion, the inverse of the preset compand stages. Combine with
+ * skipCompand, or the preset's compressor will squash the restored range again.
+ */
+export interface ExpanderOptions {
+  /** downward deepens quiet passages; upward lifts peaks back toward 0 dB (default downward) */
+  mode?: 'downward' | 'upward';
+  /** Level in dB where expansion starts, -80 to -1 (default -40) */
+  threshold?: number;
+  /** Expansion ratio, above 1 up to 10 (default 2) */
+  ratio?: number;
+  /** Attack time in ms (default 10) */
+  attack?: number;
+  /** Release time in ms (default 100) */
+  release?: number;
+}
+
+/** Settings for the creative telephone preset */
+export interface TelephoneOptions {
+  /** Bottom of the phone band in Hz (default 300) */
+  lowCut?: number;
+  /** Top of the phone band in Hz (default 3400) */
+  highCut?: number;
+  /** Bit depth to crush to, 2-16 (default 8; null disables) */
+  bits?: number | null;
+  /** Line hiss level in dBFS, -90 to -20 (default -50; null disables) */
+  noise?: number | null;
+}
+
 export interface ProcessAudioOptions {
+  /** Named processing profile; options given alongside it override the profile's */
+  profile?: string;
   enhanceVocals?: boolean;
   outputDir?: string;
   preset?: 'clarity' | 'warmVocal' | 'brightVocal' | string;
   userId?: string;
+  /** Stored filter chain, looked up through the chain resolver; replaces the preset */
+  chainId?: string;
+  /** Presets to run one after another; overrides preset when set */
+  presets?: string[];
+  /** Loudness values measured elsewhere; runs loudnorm single-pass with them */
+  measured?: MeasuredLoudness;
+  /** Strip the preset's compand (dynamics) stages */
+  skipCompand?: boolean;
+  /** Strip the preset's loudnorm stages */
+  skipLoudnorm?: boolean;
+  /** JSON EQ profile of { freq, width, gain } bands, relative to eqDir */
+  eqFile?: string;
+  /** Directory EQ profiles are loaded from (default resources/eq) */
+  eqDir?: string;
+  /**
+   * Noise gate run at the start of the chain. It runs before the preset's
+   * compand, whose upward curve would otherwise lift the gated noise again.
+   */
+  gate?: boolean | GateOptions;
+  /** Append a brickwall limiter after loudnorm */
+  truePeakLimit?: boolean;
+  /**
+   * Limiter ceiling in dBTP for truePeakLimit and the safety preset (default -1.0).
+   * The limiter runs at 192kHz so inter-sample peaks are held too; like
+   * loudnorm, it leaves the output at that rate unless one is pinned.
+   */
+  truePeakCeiling?: number;
+  /** Expected sha256 of the input; processing is refused on mismatch */
+  inputSha256?: string;
+  /**
+   * Copy the input's tags, and its cover art for mp3, flac and m4a output, to
+   * the output. When false, the input's tags are stripped.
+   */
+  preserveMetadata?: boolean;
+  /**
+   * Impulse response to convolve with via afir, relative to irDir. mix is
+   * the wet share from 0-1 (default 1). The response is resampled to match
+   * the input. Cannot be combined with channelFilters.
+   */
+  impulseResponse?: string | { file: string; mix?: number };
+  /** Directory impulse responses are loaded from (default resources/ir) */
+  irDir?: string;
+  /** Room ambience added just before loudnorm; true uses the small room */
+  reverb?: boolean | 'small' | 'medium' | 'hall' | ReverbOptions;
+  /**
+   * Final gain trim in dB (-30 to 30) applied after the whole chain, including
+   * loudnorm, but ahead of the truePeakLimit or safety limiter so it can't exceed the ceiling
+   */
+  postGain?: number;
+  /** Prepend adeclip and a -3 dB pre-gain to repair clipped input */
+  declip?: boolean;
+  /** Analyze the input with astats and report whether it is clipped */
+  detectClipping?: boolean;
+  /** Detect clipping and apply declip only when the input is clipped */
+  autoDeclip?: boolean;
+  /** Pad shorter outputs with trailing silence to this many seconds (up to 3600) */
+  minDuration?: number;
+  /** Skip loudnorm when the input carries a tagPreset comment from an earlier pass */
+  skipIfAlreadyNormalized?: boolean;
+  /** Write comment=Enspira:<preset> into formats that support tags */
+  tagPreset?: boolean;
+  /**
+   * Measure the output after processing and report whether it meets the
+   * loudnorm stage's loudness and true peak targets (tolerance in LU, default 1)
+   */
+  verifyCompliance?: boolean | { tolerance?: number };
+  /** Integrated loudness target in LUFS (-70 to -5) replacing the chain's loudnorm I */
+  targetLufs?: number;
+  /** Audio file, relative to referenceDir, whose integrated loudness becomes the loudnorm target; excludes targetLufs */
+  referencePath?: string;
+  /** Directory reference tracks are loaded from (default resources/references) */
+  referenceDir?: string;
+  /** Return a per-filter breakdown of the chain that ran */
+  explain?: boolean;
+  /** Name the output after the sha256 of its contents */
+  hashNaming?: boolean;
+  /** Render a spectrogram PNG next to the output (default 1024x512) */
+  spectrogram?: boolean | { width?: number; height?: number };
+  /** ffmpeg -loglevel (default error, or warning in strict mode, or verbose with audio.debugLogging) */
+  logLevel?: 'quiet' | 'panic' | 'fatal' | 'error' | 'warning' | 'info' | 'verbose' | 'debug' | 'trace';
+  /**
+   * Tags to write to the output, e.g. { title, artist, episode_id }. Names are
+   * letters, digits and _ (up to 64); values up to 1024 characters, with control
+   * characters replaced by spaces. Ignored for formats without metadata support.
+   */
+  metadata?: Record<string, string | number>;
+  /** Fail, removing the output, if ffmpeg logs any warning while processing */
+  strict?: boolean;
+  /** Output channel layout; the input is converted to it before processing (default mono via -ac 1) */
+  channelLayout?: 'mono' | 'stereo' | '2.1' | 'quad' | '5.0' | '5.1' | '7.1';
+  /** Replace loudnorm with dynaudnorm, as is done automatically for short inputs */
+  shortInput?: boolean;
+  /**
+   * Inputs probed shorter than this skip loudnorm for dynaudnorm (default 3; 0 disables).
+   * Inputs with measured, linear or verifyCompliance set always keep loudnorm.
+   */
+  shortInputSeconds?: number;
+  /**
+   * Run the chain on 64-bit double samples to reduce rounding error in long
+   * EQ and dynamics chains. Double samples take twice the memory and are
+   * slower to filter than the default float processing, so expect a
+   * noticeably higher CPU cost on long chains.
+   */
+  highPrecision?: boolean;
+  /** Stereo balance from -1 (fully left) to 1 (fully right); requires channelLayout stereo */
+  balance?: number;
+  /** Independent per-channel gain in dB (-30 to 30); requires channelLayout stereo */
+  channelGains?: { left?: number; right?: number };
+  /** Measure mono input as dual mono, as it is heard on two speakers (loudnorm dual_mono) */
+  dualMono?: boolean;
+  /**
+   * loudnorm mode: true for linear gain (requires measured), false to force
+   * dynamic normalization even with measured values. Default: linear with measured, dynamic without.
+   */
+  linear?: boolean;
+  /** Expand dynamics on over-compressed input, ahead of the preset's dynamics stages */
+  expander?: boolean | ExpanderOptions;
+  /** Customizes the telephone preset; requires it to be among the presets in use */
+  telephone?: TelephoneOptions;
+  /** Relax loudnorm's loudness range target to its maximum to preserve dynamics */
+  disableLra?: boolean;
+  /**
+   * Separate filters for the left and right channels of a stereo input,
+   * applied before the channels are rejoined and the main chain runs.
+   * Set channelLayout to stereo to keep the channels apart in the output.
+   */
+  channelFilters?: [string[], string[]];
+  /** Threads for the filtergraph (1-32), separate from the codec's -threads */
+  filterThreads?: number;
+  /**
+   * Write one output per sample rate (up to 8, 8000-192000 Hz) from a single
+   * filter pass; each file gets a _<rate> suffix and outputPath is the first
+   */
+  sampleRates?: number[];
+  /**
+   * Registered output format name: wav (default), flac, mp3, ogg, m4a, pcm
+   * (headerless samples, described in the result's pcm field) or a custom one
+   */
+  outputFormat?: string;
+  /** Bitrate for lossy formats, 32k-512k (default the format's) */
+  bitrate?: string;
+  /**
+   * PCM sample format for WAV or raw PCM output (default the format's codec, s16).
+   * float writes 32-bit IEEE float, so levels above 0 dBFS survive for further processing.
+   */
+  sampleFormat?: 's16' | 's24' | 's32' | 'float';
+  /** PCM byte order (default le); be is only available for raw PCM formats, not WAV */
+  byteOrder?: 'le' | 'be';
+  /** Appended to the output filename before the extension (letters, digits, _ and -) */
+  outputSuffix?: string;
+  /** Input codecs transcoded to an intermediate PCM WAV first; [] disables the ffprobe check */
+  preconvertCodecs?: string[];
+  /** Times to retry ffmpeg after a transient I/O failure (default 0) */
+  retries?: number;
+  /** Base delay before the first retry, doubled on each attempt (default 500) */
+  retryDelayMs?: number;
+}
+
+/** Outcome of the input clipping analysis */
+export interface ClippingReport {
+  /** Whether enough samples sit at full scale to count as clipped */
+  clipped: boolean;
+  /** Overall peak level in dBFS */
+  peakLevelDb: number;
+  /** Number of samples at the peak level */
+  peakCount: number;
+  /** Whether declip stages were added because of the clipping */
+  remediated: boolean;
+}
+
+/** One stage of the explained filter chain */
+export interface FilterExplanation {
+  /** Filter string as passed to ffmpeg */
+  filter: string;
+  /** ffmpeg filter name, e.g. equalizer */
+  name: string;
+  /** Filter options; unnamed options are keyed by position */
+  params: Record<string, string>;
+  /** What the stage does, from the preset when it came from one */
+  description: string | null;
+  /** Stereo channel the filter runs on, for channelFilters stages */
+  channel?: 'left' | 'right';
+}
+
+/** Output loudness checked against the chain's loudnorm targets */
+export interface ComplianceReport {
+  /** Integrated loudness within tolerance and true peak at or under the ceiling */
+  compliant: boolean;
+  integratedLufs: number;
+  truePeakDbtp: number;
+  targetLufs: number;
+  targetTruePeak: number;
+  /** Allowed integrated loudness deviation in LU */
+  tolerance: number;
+}
+
+/** Result of processAudioDetailed */
+export interface ProcessAudioResult {
+  /** Path to the processed file, relative to the output directory */
+  outputPath: string;
+  /** True when the input already matched the output and was copied without ffmpeg */
+  passthrough?: boolean;
+  /** Every output with its rate, when sampleRates is set */
+  outputs?: { sampleRate: number; outputPath: string }[];
+  /**
+   * How to read headerless PCM output, e.g. { encoding: 's24le', sampleRate: 48000, channels: 1 };
+   * with sampleRates, each output has its own rate and sampleRate is the first's
+   */
+  pcm?: { encoding: string; sampleRate: number; channels: number };
+  /** Filter chain that was actually applied; channelFilters stages come first, left then right */
+  filters: string[];
+  /** Effective parameters of the loudnorm stage, or null if none ran */
+  loudnorm: Record<string, string> | null;
+  /** Wall-clock processing time in milliseconds */
+  processingMs: number;
+  /** Spectrogram image path, when requested */
+  spectrogram?: string;
+  /** Preset key from the input's tagPreset comment, when it was processed before */
+  previousPass?: string;
+  /** Per-filter breakdown of the chain, when explain is set */
+  explanation?: FilterExplanation[];
+  /** Measured output loudness against the targets, when verifyCompliance is set */
+  compliance?: ComplianceReport;
+  /** Reference track and its measured loudness, when referencePath is set */
+  reference?: { path: string; integratedLufs: number };
+  /** Outcome of the post-process hook, when one is configured */
+  postHook?: { exitCode: number | null };
+  /** Input clipping analysis, when detectClipping or autoDeclip is set */
+  clipping?: ClippingReport;
 }
 
 /**
  * Processes audio using ffmpeg in a way that maximizes performance
  * @param inputFilePath - Path to the input WAV file
  * @param options - Processing options
- * @returns Path to the processed file (synchronous return)
+ * @returns Path to the processed file
  */
 export function processAudio(
   inputFilePath: string,
   options?: ProcessAudioOptions
-): string;
+): Promise<string>;
+
+/**
+ * Processes audio like processAudio, but also reports how it was processed
+ * @param inputFilePath - Path to the input WAV file
+ * @param options - Processing options
+ * @returns Output path and the filter chain that ran
+ */
+export function processAudioDetailed(
+  inputFilePath: string,
+  options?: ProcessAudioOptions
+): Promise<ProcessAudioResult>;
+
+/** One output of renderPresetVariants */
+export interface PresetVariant {
+  preset: string;
+  /** Path to the processed file, relative to the output directory */
+  outputPath: string;
+}
+
+/**
+ * Renders one output per preset from the same input, for comparing
+ * presets side by side
+ * @param inputFilePath - Path to the input WAV file
+ * @param presetNames - Presets to render; each must exist
+ * @param options - Processing options shared by every render
+ * @returns The outputs in the order requested
+ */
+export function renderPresetVariants(
+  inputFilePath: string,
+  presetNames: string[],
+  options?: Omit<ProcessAudioOptions, 'preset' | 'presets' | 'outputSuffix'>
+): Promise<PresetVariant[]>;
+
+/** State of the ffmpeg circuit breaker */
+export interface CircuitBreakerState {
+  /** True while processing is refused */
+  open: boolean;
+  /** ffmpeg failures since the last success */
+  consecutiveFailures: number;
+  /** Time left in the cooldown, 0 when closed */
+  retryAfterMs: number;
+}
+
+/**
+ * Enables a circuit breaker that refuses processing for cooldownMs (default
+ * 60000) once ffmpeg has failed threshold (default 5) times in a row.
+ * Refused jobs throw an error with code CIRCUIT_OPEN.
+ * @param settings - Breaker settings, or null to disable
+ */
+export function setCircuitBreaker(settings: { threshold?: number; cooldownMs?: number } | null): void;
+
+/**
+ * Gets whether the circuit breaker is currently refusing work
+ * @returns The state, or null when no breaker is configured
+ */
+export function getCircuitBreakerState(): CircuitBreakerState | null;
+
+/**
+ * Switches ffmpeg's default log level to verbose for diagnosing filter chains
+ * @param enabled - Whether to log verbosely
+ */
+export function setFfmpegDebugLogging(enabled: boolean): void;
+
+/**
+ * Sets the niceness every later ffmpeg run is launched with, so processing
+ * yields CPU to other services on a shared host. Ignored on Windows.
+ * @param niceness - Integer from -20 to 19, or null to disable
+ */
+export function setFfmpegNiceness(niceness: number | null): void;
+
+/** Command run after every successful output */
+export interface PostProcessHook {
+  /** Executable to run; no shell is involved */
+  command: string;
+  /** Arguments; {output} is replaced with the absolute output path (default ['{output}']) */
+  args?: string[];
+  /** Time the hook may run before it is killed (default 30000) */
+  timeoutMs?: number;
+  /** Fail the processing call when the hook fails, instead of only logging it */
+  failOnError?: boolean;
+}
+
+/**
+ * Sets a command to run after every successful output
+ * @param hook - Hook definition, or null to remove it
+ */
+export function setPostProcessHook(hook: PostProcessHook | null): void;
+
+/** Maps a stored chain ID to its filter strings, or null when unknown */
+export type ChainResolver = (chainId: string) => string[] | null;
+
+/**
+ * Creates a chain resolver that reads named chains from JSON files, each
+ * holding an array of filter strings (or { filters: [...] })
+ * @param chainDir - Directory holding <chainId>.json files
+ */
+export function createDirectoryChainResolver(chainDir: string): ChainResolver;
+
+/**
+ * Replaces the chain resolver (default: resources/chains), e.g. with one backed by a database
+ * @param resolver - Resolver used for the chainId option
+ */
+export function setChainResolver(resolver: ChainResolver): void;
+
+/** Waveform data for drawing a processed file */
+export interface WaveformPeaks {
+  /** Sample rate of the analyzed file */
+  sampleRate: number;
+  /** Samples covered by each peak pair */
+  samplesPerPeak: number;
+  /** [min, max] pairs in the range -1 to 1 */
+  peaks: [number, number][];
+}
+
+/**
+ * Computes min/max peak pairs for drawing a waveform. At most 20000 pairs
+ * are returned; the window widens when the file would need more. Inputs
+ * longer than 30 minutes are rejected with code WAVEFORM_TOO_LONG, and
+ * headerless PCM files with code RAW_PCM.
+ * @param audioFilePath - Audio file to analyze
+ * @param options - samplesPerPeak window size (32-65536, default 1024), or
+ *   width, the number of peaks wanted (1-20000); not both
+ */
+export function computeWaveformPeaks(
+  audioFilePath: string,
+  options?: { samplesPerPeak?: number; width?: number }
+): Promise<WaveformPeaks>;
+
+/** Requirements inputs must meet, checked with ffprobe before processing */
+export interface InputRules {
+  /** Lowest accepted sample rate in Hz */
+  minSampleRate?: number;
+  /** Highest accepted sample rate in Hz */
+  maxSampleRate?: number;
+  minChannels?: number;
+  maxChannels?: number;
+  /** Longest accepted input in seconds */
+  maxDuration?: number;
+  /** Accepted ffprobe codec names, e.g. pcm_s16le */
+  codecs?: string[];
+}
+
+/**
+ * Sets the requirements every input is checked against; inputs that break
+ * them, or can't be probed, are rejected before processing
+ * @param rules - Rules to enforce, or null to accept everything
+ */
+export function setInputRules(rules: InputRules | null): void;
+
+/**
+ * Restricts which registered output formats may be requested
+ * @param names - Permitted format names, or null to permit every registered format
+ */
+export function setAllowedOutputFormats(names: string[] | null): void;
+
+/**
+ * Sets the append-only audit log (JSON lines) recording each completed job's
+ * input and output hashes, filters, user and duration
+ * @param filePath - Log file, or null to disable
+ */
+export function setAuditLogPath(filePath: string | null): void;
+
+/** An entry in the output format registry */
+export interface OutputFormatDefinition {
+  /** ffmpeg audio codec, e.g. pcm_s16le */
+  codec: string;
+  /** File extension without the dot */
+  extension: string;
+  /** ffmpeg muxer passed to -f */
+  muxer: string;
+  /** Default bitrate passed to -b:a, if any */
+  bitrate?: string | null;
+  /** Content type used when serving the file */
+  contentType?: string;
+  /** Whether the container can carry metadata tags (default false) */
+  metadata?: boolean;
+  /** Extra output arguments, e.g. ['-movflags', '+faststart'] */
+  outputArgs?: string[];
+}
+
+/**
+ * Adds or replaces an output format in the registry
+ * @param name - Format name used by the outputFormat option
+ * @param definition - Codec, extension, muxer and defaults
+ */
+export function registerOutputFormat(name: string, definition: OutputFormatDefinition): void;
+
+/**
+ * Finds the content type for a file extension written by a registered format
+ * @param extension - Extension with or without the leading dot
+ * @returns Content type, or null for unknown extensions
+ */
+export function getOutputContentType(extension: string): string | null;
+
+/**
+ * Adds or replaces a named processing profile
+ * @param name - Profile name used by the profile option
+ * @param profileOptions - Processing options the profile applies
+ */
+export function registerProcessingProfile(
+  name: string,
+  profileOptions: Omit<ProcessAudioOptions, 'profile'>
+): void;
+
+/** Result of validateFilterChain */
+export interface FilterValidationResult {
+  valid: boolean;
+  /** ffmpeg's error output when the chain is rejected */
+  error: string | null;
+}
+
+/**
+ * Checks whether ffmpeg accepts a filter chain without processing any file.
+ * Only an allow-list of common audio filters is accepted, and ffmpeg is
+ * killed if the check takes longer than 5 seconds.
+ * @param filters - Filter strings to validate
+ * @returns Whether the chain is valid, with ffmpeg's error if not
+ */
+export function validateFilterChain(filters: string[]): Promise<FilterValidationResult>;
+
+/**
+ * Sets the size limits every filter chain is checked against
+ * @param limits - Maximum filter count (default 64) and combined length (default 8192)
+ */
+export function setFilterChainLimits(limits: { maxFilters?: number; maxFilterLength?: number }): void;
+
+/**
+ * Checks a filter chain against the configured size limits; an entry
+ * holding a comma-separated chain counts as each of its filters
+ * @param filters - Filter strings to check
+ * @returns Error message, or null when within limits
+ */
+export function checkFilterChainLimits(filters: string[]): string | null;
+
+/**
+ * Runs a tiny ffmpeg job so the first real request doesn't pay startup costs
+ * @returns Whether the warm-up job succeeded
+ */
+export function warmUpFfmpeg(): Promise<boolean>;
+
+/**
+ * Estimates how long a job will take from the preset's observed rate
+ * @param presetKey - Preset name, or several joined with '+'
+ * @param durationSeconds - Input duration in seconds
+ * @returns Estimated milliseconds, or null with no history yet
+ */
+export function estimateProcessingTime(presetKey: string, durationSeconds: number): number | null;
+
+/**
+ * Lists the rolling processing rate of every preset that has run
+ * @returns Map of preset key to milliseconds per second of input
+ */
+export function getProcessingRates(): Record<string, number>;
+
+/** A single preset stage with an optional human-readable description */
+export interface PresetStage {
+  filter: string;
+  description: string | null;
+}
+
+/** Map of preset name to its annotated stages */
+export type PresetCatalog = Record<string, PresetStage[]>;
 
 /**
  * Process multiple audio files in sequence
@@ -30,7 +583,22 @@ export function processAudio(
 export function batchProcessAudio(
   inputFiles: string[],
   options?: ProcessAudioOptions
-): string[];
+): Promise<string[]>;
+
+/**
+ * Lists every built-in preset with its annotated stages
+ * @returns Map of preset name to its stages
+ */
+export function getPresetCatalog(): PresetCatalog;
+
+/** Whether a preset corrects the voice or deliberately colors it */
+export type PresetCategory = 'corrective' | 'creative';
+
+/**
+ * Get the category of every built-in preset
+ * @returns Map of preset name to its category
+ */
+export function getPresetCategories(): Record<string, PresetCategory>;
 
 /**
  * Clean up old audio files from a directory
@@ -53,3 +621,32 @@ export function scheduleCleanup(
   days?: number,
   intervalHours?: number
 ): void;
+
+/**
+ * Sets the output directory's disk budget; oldest files are evicted to make
+ * room for each new output, and scheduled cleanups also apply it
+ * @param bytes - Maximum total size in bytes, or null to disable
+ */
+export function setMaxDirectoryBytes(bytes: number | null): void;
+
+/**
+ * Delete the oldest files in a directory until it fits a size budget
+ * @param directory - Directory to trim
+ * @param maxBytes - Maximum total size of the directory's files
+ * @param keepFilePaths - Files that must never be evicted, e.g. every output of the current job
+ * @returns Number of files deleted
+ */
+export function enforceDiskLimit(
+  directory: string,
+  maxBytes: number,
+  keepFilePaths?: Iterable<string>
+): number;
+
+/**
+ * Resolves a path that must stay inside a directory; absolute paths, ..
+ * segments and symlinks pointing out of the directory are rejected
+ * @param directory - Allowed directory, relative to the working directory
+ * @param target - Path relative to the directory
+ * @returns Absolute path with symlinks followed, or null when it escapes
+ */
+export function resolveContainedPath(directory: string, target: string): string | null;
@@ -1,222 +1,2520 @@
 // ffmpeg-processor.js - Fixed version with proper promise handling
 import fs from 'fs-extra';
 import path from 'path';
-import { execFileSync } from 'child_process';
+import { execFileSync, spawn } from 'child_process';
+import crypto from 'crypto';
+import os from 'os';
+import { performance } from 'node:perf_hooks';
 import { logger } from './create-global-logger.js';
 
 /**
  * Processes audio using ffmpeg in a way that maximizes performance
  * @param {string} inputFilePath - Path to the input WAV file
  * @param {Object} options - Processing options
- * @returns {string} - Path to the processed file (synchronous return)
+ * @returns {Promise<string>} - Path to the processed file
  */
-export function processAudio(inputFilePath, options = {}) {
+export async function processAudio(inputFilePath, options = {}) {
+    return (await processAudioDetailed(inputFilePath, options)).outputPath;
+}
+
+/**
+ * Processes audio like processAudio, but also reports how it was processed
+ * @param {string} inputFilePath - Path to the input WAV file
+ * @param {Object} options - Processing options
+ * @param {Object|null} checkedInput - { path, sha256 } of the original input when the caller
+ *   has already checked it against the input rules; inputFilePath may then be a decoded copy
+ * @param {Array<string>} keepFilePaths - Earlier outputs of the same job that the disk budget must not evict
+ * @returns {Promise<Object>} - { outputPath, filters, processingMs } where filters is the chain that ran
+ */
+export async function processAudioDetailed(inputFilePath, requestOptions = {}, checkedInput = null, keepFilePaths = []) {
+    const options = applyProcessingProfile(requestOptions);
     const {
-        enhanceVocals = true,
         outputDir = 'final',
-        preset = 'clarity',
-        userId = 'null'
+        userId = 'null',
+        retries = 0,
+        retryDelayMs = 500,
+        hashNaming = false,
+        spectrogram = false,
+        logLevel = undefined,
+        inputSha256 = null,
+        preserveMetadata = false,
+        outputFormat = 'wav',
+        channelLayout = null,
+        preconvertCodecs = PRECONVERT_CODECS,
+        channelFilters = null,
+        filterThreads = null,
+        tagPreset = false,
+        detectClipping = false,
+        autoDeclip = false,
+        shortInputSeconds = SHORT_INPUT_SECONDS,
+        skipIfAlreadyNormalized = false,
+        explain = false,
+        outputSuffix = '',
+        sampleFormat = null,
+        byteOrder = null,
+        impulseResponse = null,
+        irDir = 'resources/ir',
+        sampleRates = null,
+        bitrate = null,
+        verifyCompliance = false,
+        strict = false,
+        referencePath = null,
+        referenceDir = 'resources/references',
+        metadata = null
     } = options;
 
+    const startTime = performance.now();
+    let sourceFilePath = inputFilePath;
+    let intermediateFilePath = null;
+
     try {
+        const breaker = getCircuitBreakerState();
+        if (breaker && breaker.open) {
+            const error = new Error(`Audio processing is suspended after repeated ffmpeg failures; retry in ${Math.ceil(breaker.retryAfterMs / 1000)}s`);
+            error.code = 'CIRCUIT_OPEN';
+            throw error;
+        }
+
+        // Reject corrupted or truncated inputs before spending time in ffmpeg
+        const inputHash = inputSha256 || (auditLogPath && !checkedInput) ? hashFile(inputFilePath) : null;
+        if (inputSha256) {
+            if (inputHash !== inputSha256.toLowerCase()) {
+                throw new Error(`Input checksum mismatch for ${inputFilePath}: expected ${inputSha256}, got ${inputHash}`);
+            }
+        }
+
+        const format = getOutputFormat(outputFormat);
+        // Headerless output can't describe itself, so the sample rate has to be known
+        const rawOutput = isRawPcm(format);
+
+        const probe = preconvertCodecs.length > 0 || skipIfAlreadyNormalized || inputRules || rawOutput
+            ? tryProbeAudio(inputFilePath)
+            : null;
+        if (rawOutput && !sampleRates && !probe) {
+            throw new Error(`Raw PCM output needs the input's sample rate, but ${inputFilePath} could not be probed`);
+        }
+        if (inputRules && !checkedInput) {
+            checkInputRules(inputFilePath, probe);
+        }
+
+        // Some codecs need a plain PCM pass before the filter chain behaves
+        if (probe && preconvertCodecs.includes(probe.codec)) {
+            intermediateFilePath = path.join(os.tmpdir(), `enspira_${crypto.randomUUID()}.wav`);
+            logger.log("Audio", `Converting ${probe.codec} input ${inputFilePath} to PCM before processing`);
+            await runFfmpeg(["-nostdin", "-y", "-i", inputFilePath, "-c:a", "pcm_s16le", intermediateFilePath], {
+                retries,
+                retryDelayMs,
+                logLevel
+            });
+            sourceFilePath = intermediateFilePath;
+        }
+
+        // Clipped input throws off compand and loudnorm, so look for it first
+        const clipping = detectClipping || autoDeclip ? await measureClipping(sourceFilePath) : null;
+        if (clipping) {
+            clipping.remediated = Boolean(autoDeclip && clipping.clipped);
+            if (clipping.clipped) {
+                logger.warn("Audio", `Input ${inputFilePath} appears clipped (${clipping.peakCount} samples at ${clipping.peakLevelDb} dBFS)`);
+            }
+        }
+
         // Prepare paths
         const outputDirectory = path.resolve(process.cwd(), outputDir);
         const inputFileName = path.basename(inputFilePath, path.extname(inputFilePath));
-        const outputFileName = `${userId}_${inputFileName}.wav`;
-        const outputFilePath = path.join(outputDirectory, outputFileName);
+        if (!/^[\w-]*$/.test(outputSuffix)) {
+            throw new Error(`Output suffix may only contain letters, digits, _ and -: ${outputSuffix}`);
+        }
+        // One output normally; one per rate, from a single filter pass, with sampleRates
+        const outputs = (sampleRates ? validateSampleRates(sampleRates) : [null]).map(sampleRate => {
+            const fileName = `${userId}_${inputFileName}${outputSuffix}${sampleRate ? `_${sampleRate}` : ''}.${format.extension}`;
+            return { sampleRate, fileName, filePath: path.join(outputDirectory, fileName) };
+        });
         
         // Ensure output directory exists
         fs.ensureDirSync(outputDirectory);
         
         // Get filter string based on preset
-        const filterString = enhanceVocals ? getPresetFilters(preset).join(',') : '';
+        const chainOptions = { ...options };
+        if (clipping && clipping.remediated) {
+            chainOptions.declip = true;
+        }
+        // loudnorm's gain goes wild on clips too short to measure reliably
+        if (probe && probe.duration > 0 && probe.duration < shortInputSeconds) {
+            // Explicit loudnorm settings only make sense with loudnorm, so keep it for them
+            if (options.measured || (options.linear !== undefined && options.linear !== null) || verifyCompliance) {
+                logger.warn("Audio", `Input ${inputFilePath} is ${probe.duration}s long, but keeping loudnorm for its measured, linear or verifyCompliance options`);
+            } else {
+                logger.log("Audio", `Input ${inputFilePath} is ${probe.duration}s long; using dynaudnorm instead of loudnorm`);
+                chainOptions.shortInput = true;
+            }
+        }
+        // A tagPreset comment means an earlier Enspira pass already ran loudnorm
+        const previousPass = probe ? getEnspiraTag(probe.tags) : null;
+        if (previousPass) {
+            if (skipIfAlreadyNormalized) {
+                logger.log("Audio", `Input ${inputFilePath} was already processed with ${previousPass}; skipping loudnorm`);
+                chainOptions.skipLoudnorm = true;
+            } else {
+                logger.warn("Audio", `Input ${inputFilePath} was already processed with ${previousPass}; loudnorm will run again`);
+            }
+        }
+        // Match a reference track's loudness instead of the preset's fixed target
+        const reference = referencePath ? await measureReferenceLoudness(referencePath, referenceDir) : null;
+        if (reference) {
+            if (options.targetLufs !== undefined) {
+                throw new Error('referencePath and targetLufs cannot be combined');
+            }
+            chainOptions.targetLufs = reference.integratedLufs;
+        }
+        const filters = buildFilterChain(chainOptions);
+        const filterString = filters.join(',');
+        // Per-channel filters run ahead of the main chain, so they count toward its limits and are reported with it
+        const channelChains = channelFilters ? validateChannelFilters(channelFilters) : null;
+        const reportedFilters = channelChains ? [...channelChains[0], ...channelChains[1], ...filters] : filters;
+        if (channelChains) {
+            const limitError = checkFilterChainLimits(reportedFilters);
+            if (limitError) {
+                throw new Error(limitError);
+            }
+        }
+        
+        const channels = channelLayout ? getChannelCount(channelLayout) : 1;
+        const inputArgs = ["-i", sourceFilePath];
+        let filterArgs;
+        if (impulseResponse) {
+            if (channelFilters) {
+                throw new Error('impulseResponse cannot be combined with channelFilters');
+            }
+            // afir needs the impulse response at the input's sample rate
+            const inputProbe = probe || tryProbeAudio(sourceFilePath);
+            if (!inputProbe || !inputProbe.sampleRate) {
+                throw new Error(`Could not determine the sample rate of ${inputFilePath} for convolution`);
+            }
+            const irFile = typeof impulseResponse === 'object' ? impulseResponse.file : impulseResponse;
+            inputArgs.push("-i", resolveImpulseResponse(irFile, irDir));
+            filterArgs = ["-filter_complex", buildConvolutionGraph(filters, inputProbe.sampleRate), "-map", "[out]"];
+        } else if (channelFilters) {
+            filterArgs = ["-filter_complex", buildChannelSplitGraph(channelChains, filterString), "-map", "[out]"];
+        } else {
+            filterArgs = ["-af", filterString];
+        }
+        if (sampleRates) {
+            const graph = filterArgs[0] === "-filter_complex" ? filterArgs[1] : `[0:a]${filterString || 'anull'}[out]`;
+            filterArgs = ["-filter_complex", `${graph};${buildResampleSplit(outputs.map(output => output.sampleRate))}`];
+        }
+        const threadArgs = [];
+        if (filterThreads !== null) {
+            if (!(Number.isInteger(filterThreads) && filterThreads >= 1 && filterThreads <= MAX_FILTER_THREADS)) {
+                throw new Error(`filterThreads must be an integer between 1 and ${MAX_FILTER_THREADS}: ${filterThreads}`);
+            }
+            // Filtergraph threading is separate from the codec's -threads
+            threadArgs.push(filterArgs[0] === "-filter_complex" ? "-filter_complex_threads" : "-filter_threads", String(filterThreads));
+        }
+
+        const { codec, muxer } = sampleFormat || byteOrder
+            ? getPcmEncoding(format, sampleFormat, byteOrder || 'le')
+            : format;
+        const encodeArgs = ["-ac", String(channels), "-threads", "8", "-c:a", codec];
+        if (bitrate) {
+            encodeArgs.push("-b:a", validateBitrate(format, bitrate));
+        } else if (format.bitrate) {
+            encodeArgs.push("-b:a", format.bitrate);
+        }
+        if (rawOutput && !sampleRates) {
+            // Pin the rate reported in the result; loudnorm would otherwise leave 192kHz
+            encodeArgs.push("-ar", String(probe.sampleRate));
+        }
+        encodeArgs.push(...(format.outputArgs || []));
+        if (preserveMetadata) {
+            encodeArgs.push("-map_metadata", "0");
+            if (ATTACHED_PICTURE_MUXERS.has(muxer)) {
+                // Cover art is a video stream, which an explicit -map drops unless it's named too
+                const audioMap = filterArgs[0] === "-af" ? ["-map", "0:a"] : [];
+                encodeArgs.push(...audioMap, "-map", "0:v?", "-c:v", "copy", "-disposition:v", "attached_pic");
+            }
+        } else {
+            // ffmpeg copies the input's global tags unless told not to
+            encodeArgs.push("-map_metadata", "-1");
+        }
+        if (metadata) {
+            if (format.metadata) {
+                const tags = sanitizeMetadata(metadata);
+                if (tagPreset && 'comment' in tags) {
+                    throw new Error('The comment tag is reserved for tagPreset');
+                }
+                for (const [key, value] of Object.entries(tags)) {
+                    encodeArgs.push("-metadata", `${key}=${value}`);
+                }
+            } else {
+                logger.warn("Audio", `${format.extension} output can't carry metadata tags; ignoring them`);
+            }
+        }
+        if (tagPreset && format.metadata) {
+            // Records which preset produced the file for later auditing
+            encodeArgs.push("-metadata", `comment=Enspira:${getPresetKey(options)}`);
+        }
+        encodeArgs.push("-f", muxer);
+
+        const ffmpegArgs = ["-nostdin", "-y", ...threadArgs, ...inputArgs, ...filterArgs];
+        outputs.forEach((output, index) => {
+            const mapArgs = sampleRates ? ["-map", `[o${index}]`] : [];
+            ffmpegArgs.push(...mapArgs, ...encodeArgs, output.filePath);
+        });
         
-        // Create the ffmpeg command
-        const ffmpegCommand = `ffmpeg -y -i "${inputFilePath}" -af "${filterString}" -ar 48000 -ac 1 -codec:a pcm_s24le -threads 4 "${outputFilePath}"`;
+        // Nothing to filter and the input already matches the output: copy instead of re-encoding
+        const passthrough = filters.length === 0 && !sampleRates && !channelFilters && !tagPreset && !metadata && !bitrate && probe !== null &&
+            probe.container === muxer && probe.codec === codec && probe.channels === channels && sourceFilePath === inputFilePath &&
+            (preserveMetadata || Object.keys(probe.tags).length === 0);
+        if (passthrough) {
+            logger.log("Audio", `Input ${inputFilePath} already matches the requested output; copying without ffmpeg`);
+            fs.copyFileSync(sourceFilePath, outputs[0].filePath);
+        } else {
+            let warnings;
+            try {
+                warnings = await runFfmpeg(ffmpegArgs, {
+                    retries,
+                    retryDelayMs,
+                    logLevel,
+                    collectWarnings: strict
+                });
+            } catch (error) {
+                recordFfmpegOutcome(false);
+                throw error;
+            }
+            recordFfmpegOutcome(true);
+            if (warnings.length > 0) {
+                outputs.forEach(output => fs.removeSync(output.filePath));
+                throw new Error(`Strict mode: ffmpeg reported ${warnings.length} warning(s): ${warnings.join('; ')}`);
+            }
+        }
+        
+        for (const output of outputs) {
+            // Check that the output file exists
+            if (!fs.existsSync(output.filePath)) {
+                throw new Error(`Output file was not created: ${output.filePath}`);
+            }
+
+            // Rename to the content hash so identical outputs share a filename
+            if (hashNaming) {
+                const hash = hashFile(output.filePath);
+                output.fileName = `${hash}.${format.extension}`;
+                const hashedFilePath = path.join(outputDirectory, output.fileName);
+                fs.renameSync(output.filePath, hashedFilePath);
+                output.filePath = hashedFilePath;
+            }
+            
+            if (maxDirectoryBytes && fs.statSync(output.filePath).size > maxDirectoryBytes) {
+                fs.unlinkSync(output.filePath);
+                throw new Error(`Output ${output.fileName} is larger than the ${maxDirectoryBytes} byte directory limit`);
+            }
+        }
+
+        const [primaryOutput] = outputs;
+        const result = { outputPath: `/${primaryOutput.fileName}`, filters: reportedFilters, loudnorm: getLoudnormParams(filters) };
+        if (passthrough) {
+            result.passthrough = true;
+        }
+        if (sampleRates) {
+            result.outputs = outputs.map(output => ({ sampleRate: output.sampleRate, outputPath: `/${output.fileName}` }));
+        }
+        if (rawOutput) {
+            // There's no header, so say how to read the samples
+            result.pcm = {
+                encoding: codec.slice('pcm_'.length),
+                sampleRate: primaryOutput.sampleRate || probe.sampleRate,
+                channels
+            };
+        }
+        if (clipping) {
+            result.clipping = clipping;
+        }
+        if (previousPass) {
+            result.previousPass = previousPass;
+        }
+        if (explain) {
+            const channelExplanations = channelChains
+                ? channelChains.flatMap((chain, index) => explainFilterChain(chain)
+                    .map(entry => ({ ...entry, channel: index === 0 ? 'left' : 'right' })))
+                : [];
+            result.explanation = [...channelExplanations, ...explainFilterChain(filters)];
+        }
+
+        if (reference) {
+            result.reference = reference;
+        }
+        if (verifyCompliance) {
+            result.compliance = await checkCompliance(primaryOutput.filePath, result.loudnorm, verifyCompliance === true ? {} : verifyCompliance, getRawInputArgs(result.pcm));
+        }
+
+        if (spectrogram) {
+            result.spectrogram = await renderSpectrogram(primaryOutput.filePath, spectrogram === true ? {} : spectrogram, getRawInputArgs(result.pcm));
+        }
+
+        // Keep the output directory within the server's disk budget, without evicting anything this job wrote
+        if (maxDirectoryBytes) {
+            enforceDiskLimit(outputDirectory, maxDirectoryBytes, [
+                ...keepFilePaths,
+                ...outputs.map(output => output.filePath),
+                ...(result.spectrogram ? [path.join(outputDirectory, result.spectrogram)] : [])
+            ]);
+        }
         
-        // Log the command
-        logger.debug("Audio", `Executing command: ${ffmpegCommand}`);
+        if (postProcessHook) {
+            // Report the first failure, if any, across every output
+            const hookResults = [];
+            for (const output of outputs) {
+                hookResults.push(await runPostProcessHook(output.filePath));
+            }
+            result.postHook = hookResults.find(hook => hook.exitCode !== 0) || hookResults[0];
+        }
         
-        // Execute ffmpeg command synchronously
-        execFileSync("ffmpeg", ["-nostdin", "-y", "-i", inputFilePath, "-af", filterString, "-ac", "1", "-threads", "8", outputFilePath], {
-            stdio: ['ignore'] // Capture stderr only for errors
+        result.processingMs = Math.round(performance.now() - startTime);
+        if (probe && probe.duration > 0) {
+            recordProcessingTime(getPresetKey(options), result.processingMs, probe.duration);
+        }
+        if (auditLogPath) {
+            writeAuditRecord({
+                time: new Date().toISOString(),
+                user_id: userId,
+                input: checkedInput ? checkedInput.path : inputFilePath,
+                input_sha256: checkedInput ? checkedInput.sha256 : inputHash,
+                outputs: outputs.map(output => ({ path: output.filePath, sha256: hashFile(output.filePath) })),
+                filters: reportedFilters,
+                processing_ms: result.processingMs
+            });
+        }
+        logger.log("Audio", `Successfully processed audio to ${outputs.map(output => output.filePath).join(', ')} in ${result.processingMs}ms`);
+        return result;
+    } catch (error) {
+        logger.error("Audio", `Error processing audio: ${error.message}`);
+        throw error; // Re-throw to let caller handle it
+    } finally {
+        if (intermediateFilePath) {
+            fs.removeSync(intermediateFilePath);
+        }
+    }
+}
+
+/** Rolling average processing milliseconds per second of input, keyed by preset */
+const processingRates = {};
+
+/** Weight given to the newest sample in the rolling average */
+const PROCESSING_RATE_SMOOTHING = 0.2;
+
+/**
+ * Names the preset combination a set of options runs, for timing stats
+ * @param {Object} options - Processing options
+ * @returns {string} - Preset key
+ */
+function getPresetKey({ enhanceVocals = true, preset = 'clarity', presets = null, chainId = null }) {
+    if (chainId) {
+        return `chain:${chainId}`;
+    }
+    if (!enhanceVocals) {
+        return 'none';
+    }
+    return presets ? presets.join('+') : preset;
+}
+
+/**
+ * Folds a finished job into the rolling processing rate for its preset
+ * @param {string} presetKey - Preset key from getPresetKey
+ * @param {number} processingMs - Wall-clock processing time
+ * @param {number} durationSeconds - Input duration
+ */
+function recordProcessingTime(presetKey, processingMs, durationSeconds) {
+    const rate = processingMs / durationSeconds;
+    const previous = processingRates[presetKey];
+    processingRates[presetKey] = previous === undefined
+        ? rate
+        : previous + PROCESSING_RATE_SMOOTHING * (rate - previous);
+}
+
+/**
+ * Estimates how long a job will take from the preset's observed rate
+ * @param {string} presetKey - Preset name, or several joined with '+'
+ * @param {number} durationSeconds - Input duration
+ * @returns {number|null} - Estimated milliseconds, or null with no history yet
+ */
+export function estimateProcessingTime(presetKey, durationSeconds) {
+    const rate = processingRates[presetKey];
+    return rate === undefined ? null : Math.round(rate * durationSeconds);
+}
+
+/**
+ * Lists the rolling processing rate of every preset that has run
+ * @returns {Object} - Map of preset key to milliseconds per second of input
+ */
+export function getProcessingRates() {
+    return Object.fromEntries(
+        Object.entries(processingRates).map(([key, rate]) => [key, Math.round(rate)])
+    );
+}
+
+/** Upper bound on the number of outputs the sampleRates option can request */
+const MAX_SAMPLE_RATE_OUTPUTS = 8;
+
+/** Upper bound for the filterThreads option */
+const MAX_FILTER_THREADS = 32;
+
+/** Muxers that can carry cover art as an attached picture stream */
+const ATTACHED_PICTURE_MUXERS = new Set(['mp3', 'flac', 'ipod', 'mp4']);
+
+/** Input codecs that are transcoded to PCM before the filter chain runs */
+const PRECONVERT_CODECS = ['amr_nb', 'amr_wb', 'gsm', 'gsm_ms', 'qcelp', 'evrc', 'truespeech', 'g723_1', 'g729'];
+
+/**
+ * Reads the first audio stream's properties with ffprobe
+ * @param {string} filePath - Audio file to inspect
+ * @returns {Object} - { codec, container, sampleRate, channels, duration, tags }
+ */
+function probeAudio(filePath) {
+    const output = execFileSync("ffprobe", [
+        "-v", "error",
+        "-print_format", "json",
+        "-show_streams",
+        "-show_format",
+        "-select_streams", "a:0",
+        filePath
+    ]);
+    const { streams = [], format = {} } = JSON.parse(output.toString());
+    const stream = streams[0];
+    if (!stream) {
+        throw new Error(`No audio stream found in ${filePath}`);
+    }
+
+    return {
+        codec: stream.codec_name,
+        // format_name can list aliases, e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+        container: format.format_name ? format.format_name.split(',')[0] : null,
+        sampleRate: Number(stream.sample_rate),
+        channels: stream.channels,
+        duration: Number(stream.duration || format.duration),
+        tags: { ...(format.tags || {}), ...(stream.tags || {}) }
+    };
+}
+
+/**
+ * Probes an input, logging rather than failing when ffprobe can't read it
+ * @param {string} filePath - Audio file to inspect
+ * @returns {Object|null} - Probe result, or null on failure
+ */
+function tryProbeAudio(filePath) {
+    try {
+        return probeAudio(filePath);
+    } catch (error) {
+        logger.warn("Audio", `Could not probe ${filePath}: ${error.message}`);
+        return null;
+    }
+}
+
+/**
+ * Output formats keyed by name. Each maps to the ffmpeg codec and muxer to
+ * use, the file extension to write, an optional default bitrate and
+ * whether the container can carry metadata tags.
+ */
+const outputFormats = {
+    wav: { codec: 'pcm_s16le', extension: 'wav', muxer: 'wav', bitrate: null, contentType: 'audio/wav', metadata: true },
+    flac: { codec: 'flac', extension: 'flac', muxer: 'flac', bitrate: null, contentType: 'audio/flac', metadata: true },
+    mp3: { codec: 'libmp3lame', extension: 'mp3', muxer: 'mp3', bitrate: '192k', contentType: 'audio/mpeg', metadata: true },
+    ogg: { codec: 'libopus', extension: 'ogg', muxer: 'ogg', bitrate: '96k', contentType: 'audio/ogg', metadata: true },
+    // faststart moves the moov atom to the front so playback can begin before the download finishes
+    m4a: { codec: 'aac', extension: 'm4a', muxer: 'ipod', bitrate: '192k', contentType: 'audio/mp4', metadata: true, outputArgs: ['-movflags', '+faststart'] },
+    // Headerless samples; sampleFormat and byteOrder pick the encoding, reported back in the result
+    pcm: { codec: 'pcm_s16le', extension: 'pcm', muxer: 's16le', bitrate: null, contentType: 'application/octet-stream', metadata: false }
+};
+
+/**
+ * Adds or replaces an output format in the registry
+ * @param {string} name - Format name used by the outputFormat option
+ * @param {Object} definition - { codec, extension, muxer, bitrate, contentType, metadata, outputArgs }
+ */
+export function registerOutputFormat(name, definition) {
+    const {
+        codec,
+        extension,
+        muxer,
+        bitrate = null,
+        contentType = 'application/octet-stream',
+        metadata = false,
+        outputArgs = []
+    } = definition || {};
+    if (![codec, extension, muxer].every(value => typeof value === 'string' && value.length > 0)) {
+        throw new Error(`Output format ${name} needs a codec, extension and muxer`);
+    }
+    if (!/^[a-z0-9]+$/i.test(extension)) {
+        throw new Error(`Output format ${name} has an invalid extension: ${extension}`);
+    }
+
+    if (!Array.isArray(outputArgs) || !outputArgs.every(arg => typeof arg === 'string')) {
+        throw new Error(`Output format ${name} outputArgs must be an array of strings`);
+    }
+
+    outputFormats[name] = { codec, extension, muxer, bitrate, contentType, metadata, outputArgs };
+}
+
+/** Longest tag value written to an output, in characters */
+const MAX_METADATA_VALUE_LENGTH = 1024;
+
+/**
+ * Checks client-supplied tags before they're passed to ffmpeg
+ * @param {Object} metadata - Map of tag name to string or number value
+ * @returns {Object} - Tags with values as strings, stripped of control characters
+ */
+function sanitizeMetadata(metadata) {
+    if (typeof metadata !== 'object' || Array.isArray(metadata)) {
+        throw new Error('metadata must be a map of tag names to values');
+    }
+    const tags = {};
+    for (const [key, value] of Object.entries(metadata)) {
+        if (!/^[a-z][a-z0-9_]{0,63}$/i.test(key)) {
+            throw new Error(`Invalid metadata tag name: ${key}`);
+        }
+        if (typeof value !== 'string' && !Number.isFinite(value)) {
+            throw new Error(`Metadata tag ${key} must be a string or number`);
+        }
+        // Control characters (including newlines) would corrupt some containers' tag blocks
+        const text = String(value).replace(/[\u0000-\u001f\u007f]/g, ' ').trim();
+        if (text.length > MAX_METADATA_VALUE_LENGTH) {
+            throw new Error(`Metadata tag ${key} is longer than ${MAX_METADATA_VALUE_LENGTH} characters`);
+        }
+        tags[key.toLowerCase()] = text;
+    }
+    return tags;
+}
+
+/**
+ * Reads the preset key tagPreset wrote into a file's comment tag
+ * @param {Object} tags - Tags reported by probeAudio
+ * @returns {string|null} - Preset key, or null when the file carries no Enspira tag
+ */
+function getEnspiraTag(tags) {
+    // Tag names are upper case in Vorbis comments and lower case elsewhere
+    const comment = Object.entries(tags).find(([name]) => name.toLowerCase() === 'comment')?.[1];
+    return typeof comment === 'string' && comment.startsWith('Enspira:') ? comment.slice('Enspira:'.length) : null;
+}
+
+/**
+ * Checks a requested bitrate against the output format
+ * @param {Object} format - Output format definition
+ * @param {string} bitrate - Bitrate such as 256k
+ * @returns {string} - The bitrate, validated
+ */
+function validateBitrate(format, bitrate) {
+    if (!format.bitrate) {
+        throw new Error(`bitrate only applies to lossy formats, not ${format.extension}`);
+    }
+    const match = /^(\d+)k$/.exec(String(bitrate));
+    if (!match || Number(match[1]) < 32 || Number(match[1]) > 512) {
+        throw new Error(`Bitrate must be between 32k and 512k: ${bitrate}`);
+    }
+    return bitrate;
+}
+
+/**
+ * Looks up an output format by name
+ * @param {string} name - Format name
+ * @returns {Object} - Format definition
+ */
+function getOutputFormat(name) {
+    const format = outputFormats[name];
+    if (!format) {
+        throw new Error(`Unknown output format: ${name}`);
+    }
+    if (allowedOutputFormats && !allowedOutputFormats.includes(name)) {
+        throw new Error(`Output format ${name} is not allowed on this server (allowed: ${allowedOutputFormats.join(', ')})`);
+    }
+    return format;
+}
+
+/** Output formats this deployment permits; null permits every registered format */
+let allowedOutputFormats = null;
+
+/**
+ * Restricts which registered output formats may be requested, e.g. to
+ * enforce lossless-only output on an archival deployment
+ * @param {Array<string>|null} names - Permitted format names, or null to permit all
+ */
+export function setAllowedOutputFormats(names) {
+    if (names === null) {
+        allowedOutputFormats = null;
+        return;
+    }
+
+    const unknown = names.filter(name => !outputFormats[name]);
+    if (unknown.length > 0) {
+        throw new Error(`Unknown output format(s): ${unknown.join(', ')}`);
+    }
+    allowedOutputFormats = [...names];
+}
+
+/** ffmpeg PCM sample format names, without byte order, by sampleFormat option */
+const PCM_SAMPLE_FORMATS = {
+    s16: 's16',
+    s24: 's24',
+    s32: 's32',
+    float: 'f32'
+};
+
+/**
+ * Picks the PCM codec (and, for raw PCM, the muxer) for a sample format
+ * and byte order. WAV and raw PCM formats are the only ones that carry PCM.
+ * @param {Object} format - Output format definition
+ * @param {string|null} sampleFormat - Key of PCM_SAMPLE_FORMATS, or null to keep the format's
+ * @param {string} byteOrder - le or be
+ * @returns {Object} - { codec, muxer }
+ */
+function getPcmEncoding(format, sampleFormat, byteOrder) {
+    const isWav = format.muxer === 'wav';
+    if (!isWav && !isRawPcm(format)) {
+        throw new Error(`sampleFormat and byteOrder only apply to WAV or raw PCM output, not ${format.extension}`);
+    }
+    if (byteOrder !== 'le' && byteOrder !== 'be') {
+        throw new Error(`Byte order must be le or be: ${byteOrder}`);
+    }
+    if (isWav && byteOrder === 'be') {
+        throw new Error('WAV only stores little-endian PCM; use a raw PCM format for big-endian output');
+    }
+
+    const sample = sampleFormat ? PCM_SAMPLE_FORMATS[sampleFormat] : format.codec.slice(4, -2);
+    if (!sample) {
+        throw new Error(`Unsupported sample format: ${sampleFormat}`);
+    }
+    const encoding = `${sample}${byteOrder}`;
+    return { codec: `pcm_${encoding}`, muxer: isWav ? format.muxer : encoding };
+}
+
+/**
+ * Checks whether a format writes headerless PCM samples
+ * @param {Object} format - Output format definition
+ * @returns {boolean} - True for raw PCM formats
+ */
+function isRawPcm(format) {
+    // Raw PCM muxers are named after the sample format they carry, e.g. s16le
+    return /^pcm_[suf]\d+[lb]e$/.test(format.codec) && format.muxer === format.codec.slice(4);
+}
+
+/**
+ * Finds the content type for a file extension written by a registered format
+ * @param {string} extension - Extension with or without the leading dot
+ * @returns {string|null} - Content type, or null for unknown extensions
+ */
+export function getOutputContentType(extension) {
+    const bare = extension.replace(/^\./, '');
+    const format = Object.values(outputFormats).find(candidate => candidate.extension === bare);
+    return format ? format.contentType : null;
+}
+
+/**
+ * Filters a client may submit for validation: the audio filters Enspira's
+ * own chains are built from. Anything that reads or writes files, loads
+ * plugins or needs a second input is left out.
+ */
+const VALIDATABLE_FILTERS = new Set([
+    'acompressor', 'acrusher', 'adeclick', 'adeclip', 'adelay', 'aecho', 'aeval', 'afade', 'afftdn',
+    'aformat', 'agate', 'alimiter', 'allpass', 'anlmdn', 'anull', 'apad', 'aresample', 'atrim',
+    'bandpass', 'bandreject', 'bass', 'compand', 'deesser', 'dynaudnorm', 'equalizer', 'highpass',
+    'highshelf', 'loudnorm', 'lowpass', 'lowshelf', 'pan', 'speechnorm', 'stereotools', 'treble', 'volume'
+]);
+
+/** Longest a validation run may take before ffmpeg is killed */
+const VALIDATION_TIMEOUT_MS = 5000;
+
+/**
+ * Checks whether ffmpeg accepts a filter chain by running it briefly
+ * against a silent generated source; no real input or output is involved
+ * @param {Array<string>} filters - Filter strings to validate
+ * @returns {Promise<Object>} - { valid, error } where error is ffmpeg's message
+ */
+export async function validateFilterChain(filters) {
+    const names = splitFilterChain(filters)
+        .map(filter => filter.replace(/^(\[[^\]]*\])+/, '').split('=')[0]);
+    const disallowed = names.find(name => !VALIDATABLE_FILTERS.has(name));
+    if (disallowed !== undefined) {
+        return { valid: false, error: `Filter is not allowed: ${disallowed}` };
+    }
+
+    try {
+        // -t ahead of -i bounds the generated input, so filters that wait for EOF still finish;
+        // -t on the output bounds filters like apad that never end on their own
+        await runFfmpeg(["-nostdin", "-f", "lavfi", "-t", "0.1", "-i", "anullsrc", "-af", filters.join(','), "-t", "0.1", "-f", "null", "-"], {
+            timeoutMs: VALIDATION_TIMEOUT_MS
+        });
+        return { valid: true, error: null };
+    } catch (error) {
+        const stderr = error.stderr ? error.stderr.toString().trim() : '';
+        return { valid: false, error: stderr || error.message };
+    }
+}
+
+/**
+ * Runs a tiny ffmpeg job so the binary and its libraries are loaded before
+ * real traffic arrives
+ * @returns {Promise<boolean>} - Whether the warm-up job succeeded
+ */
+export async function warmUpFfmpeg() {
+    const startTime = performance.now();
+    try {
+        await runFfmpeg(["-nostdin", "-f", "lavfi", "-i", "anullsrc", "-t", "0.5", "-af", "loudnorm", "-f", "null", "-"]);
+        logger.log("Audio", `ffmpeg warm-up completed in ${Math.round(performance.now() - startTime)}ms`);
+        return true;
+    } catch (error) {
+        logger.error("Audio", `ffmpeg warm-up failed: ${error.message}`);
+        return false;
+    }
+}
+
+/** Inputs shorter than this many seconds skip loudnorm by default */
+const SHORT_INPUT_SECONDS = 3;
+
+/**
+ * Builds the dynaudnorm stage that replaces loudnorm on short inputs,
+ * keeping the loudnorm stage's true peak as its peak target
+ * @param {string} loudnorm - loudnorm filter string being replaced
+ * @returns {string} - dynaudnorm filter string
+ */
+function buildShortInputNormalizer(loudnorm) {
+    const { TP = '-2' } = getLoudnormParams([loudnorm]);
+    const peak = Math.pow(10, Number(TP) / 20).toFixed(4);
+    return `dynaudnorm=f=100:g=3:p=${peak}`;
+}
+
+/** Peak level, in dBFS, at or above which repeated peaks count as clipping */
+const CLIPPING_PEAK_DB = -0.1;
+
+/** Number of samples at the peak level needed before input counts as clipped */
+const CLIPPING_MIN_PEAK_COUNT = 8;
+
+/** Stages prepended by the declip option: repair flattened peaks, then leave headroom */
+const DECLIP_FILTERS = ['adeclip', 'volume=-3dB'];
+
+/**
+ * Runs astats over a file to see whether it was clipped before it reached us
+ * @param {string} filePath - Audio file to analyze
+ * @returns {Promise<Object>} - { clipped, peakLevelDb, peakCount }
+ */
+async function measureClipping(filePath) {
+    // astats only reports at info level and on stderr, which runFfmpeg discards
+    const analysis = await spawnFfmpeg(["-hide_banner", "-nostdin", "-loglevel", "info", "-i", filePath, "-af", "astats", "-f", "null", "-"]);
+    const { stderr } = analysis;
+    if (analysis.status !== 0) {
+        throw new Error(`Clipping analysis failed for ${filePath}: ${stderr.trim() || analysis.error?.message}`);
+    }
+
+    // The overall section is printed after the per-channel ones, so take the last values
+    const peakLevels = [...stderr.matchAll(/Peak level dB:\s*(-?[\d.]+|-inf)/g)];
+    const peakCounts = [...stderr.matchAll(/Peak count:\s*([\d.]+)/g)];
+    if (peakLevels.length === 0 || peakCounts.length === 0) {
+        throw new Error(`Clipping analysis for ${filePath} produced no statistics`);
+    }
+
+    const peakLevel = peakLevels.pop()[1];
+    const peakLevelDb = peakLevel === '-inf' ? -Infinity : Number(peakLevel);
+    const peakCount = Number(peakCounts.pop()[1]);
+    return {
+        clipped: peakLevelDb >= CLIPPING_PEAK_DB && peakCount >= CLIPPING_MIN_PEAK_COUNT,
+        peakLevelDb,
+        peakCount
+    };
+}
+
+/**
+ * Builds the arguments ffmpeg needs ahead of -i to read headerless PCM output back
+ * @param {Object|undefined} pcm - Result's { encoding, sampleRate, channels }, absent for self-describing formats
+ * @returns {Array<string>} - Input arguments, empty when the file has a header
+ */
+function getRawInputArgs(pcm) {
+    return pcm ? ["-f", pcm.encoding, "-ar", String(pcm.sampleRate), "-ac", String(pcm.channels)] : [];
+}
+
+/**
+ * Measures a file's integrated loudness and true peak with loudnorm's analysis pass
+ * @param {string} filePath - Audio file to measure
+ * @param {Array<string>} inputArgs - Arguments describing a headerless input, from getRawInputArgs
+ * @returns {Promise<Object>} - { integratedLufs, truePeakDbtp }
+ */
+async function measureLoudness(filePath, inputArgs = []) {
+    // loudnorm prints its JSON summary at info level on stderr, which runFfmpeg discards
+    const analysis = await spawnFfmpeg(["-hide_banner", "-nostdin", "-loglevel", "info", ...inputArgs, "-i", filePath, "-af", "loudnorm=print_format=json", "-f", "null", "-"]);
+    const { stderr } = analysis;
+    const summary = stderr.match(/\{[^{}]*"input_i"[^{}]*\}/);
+    if (analysis.status !== 0 || !summary) {
+        throw new Error(`Loudness measurement failed for ${filePath}: ${stderr.trim() || analysis.error?.message}`);
+    }
+
+    const { input_i, input_tp } = JSON.parse(summary[0]);
+    return { integratedLufs: Number(input_i), truePeakDbtp: Number(input_tp) };
+}
+
+/** Measured reference loudness keyed by resolved path, reused until the file changes */
+const referenceLoudnessCache = new Map();
+
+/**
+ * Measures the integrated loudness of a reference track to match other audio to
+ * @param {string} referencePath - Reference audio file, relative to referenceDir
+ * @param {string} referenceDir - Directory reference tracks must live in
+ * @returns {Promise<Object>} - { path, integratedLufs }, rounded to 0.1 LU
+ */
+async function measureReferenceLoudness(referencePath, referenceDir) {
+    // Prevent path traversal out of the reference directory
+    const trackPath = resolveContainedPath(referenceDir, referencePath);
+    if (!trackPath) {
+        throw new Error(`Reference track must be inside ${referenceDir}: ${referencePath}`);
+    }
+    if (!fs.existsSync(trackPath)) {
+        throw new Error(`Reference track not found: ${referencePath}`);
+    }
+    // The same few references are used over and over, so skip re-analysis until one is replaced
+    const { mtimeMs, size } = fs.statSync(trackPath);
+    const cached = referenceLoudnessCache.get(trackPath);
+    if (cached && cached.mtimeMs === mtimeMs && cached.size === size) {
+        return { path: referencePath, integratedLufs: cached.integratedLufs };
+    }
+
+    // Throws when there's no audio stream to measure
+    probeAudio(trackPath);
+    const { integratedLufs } = await measureLoudness(trackPath);
+    // Silence measures as -inf (or loudnorm's -70 floor), which makes no sense as a target
+    if (!(integratedLufs > -70)) {
+        throw new Error(`Reference track ${referencePath} is silent`);
+    }
+    const targetLufs = Math.min(Math.round(integratedLufs * 10) / 10, -5);
+    referenceLoudnessCache.set(trackPath, { mtimeMs, size, integratedLufs: targetLufs });
+    return { path: referencePath, integratedLufs: targetLufs };
+}
+
+/**
+ * Measures a processed file and checks it against the chain's loudnorm targets
+ * @param {string} filePath - Processed file
+ * @param {Object|null} targets - Effective loudnorm parameters ({ I, TP })
+ * @param {Object} options - { tolerance } in LU for integrated loudness (default 1)
+ * @param {Array<string>} inputArgs - Arguments describing a headerless file, from getRawInputArgs
+ * @returns {Promise<Object>} - { compliant, integratedLufs, truePeakDbtp, targetLufs, targetTruePeak, tolerance }
+ */
+async function checkCompliance(filePath, targets, { tolerance = 1 } = {}, inputArgs = []) {
+    if (!targets) {
+        throw new Error('verifyCompliance needs a loudnorm stage to take targets from');
+    }
+    if (!(tolerance > 0 && tolerance <= 5)) {
+        throw new Error(`Compliance tolerance must be between 0 and 5 LU: ${tolerance}`);
+    }
+
+    // loudnorm's defaults apply when the stage leaves a target out
+    const targetLufs = Number(targets.I ?? -24);
+    const targetTruePeak = Number(targets.TP ?? -2);
+    const { integratedLufs, truePeakDbtp } = await measureLoudness(filePath, inputArgs);
+    return {
+        compliant: Math.abs(integratedLufs - targetLufs) <= tolerance && truePeakDbtp <= targetTruePeak,
+        integratedLufs,
+        truePeakDbtp,
+        targetLufs,
+        targetTruePeak,
+        tolerance
+    };
+}
+
+/**
+ * Renders a spectrogram PNG next to a processed file
+ * @param {string} audioFilePath - Processed audio file
+ * @param {Object} options - { width, height } of the image in pixels
+ * @param {Array<string>} inputArgs - Arguments describing a headerless file, from getRawInputArgs
+ * @returns {Promise<string>} - Path to the image, relative to the output directory
+ */
+async function renderSpectrogram(audioFilePath, { width = 1024, height = 512 } = {}, inputArgs = []) {
+    if (![width, height].every(size => Number.isInteger(size) && size >= 64 && size <= 8192)) {
+        throw new Error(`Spectrogram dimensions must be integers between 64 and 8192: ${width}x${height}`);
+    }
+
+    const imageFileName = `${path.basename(audioFilePath, path.extname(audioFilePath))}.png`;
+    const imageFilePath = path.join(path.dirname(audioFilePath), imageFileName);
+
+    await runFfmpeg(["-nostdin", "-y", ...inputArgs, "-i", audioFilePath, "-lavfi", `showspectrumpic=s=${width}x${height}`, imageFilePath]);
+    return `/${imageFileName}`;
+}
+
+/** Longest audio, in seconds, a waveform will be computed for */
+const WAVEFORM_MAX_SECONDS = 1800;
+
+/** Time allowed for decoding a file for its waveform */
+const WAVEFORM_TIMEOUT_MS = 60000;
+
+/** Most [min, max] pairs a waveform returns; windows widen to stay under it */
+const WAVEFORM_MAX_PEAKS = 20000;
+
+/** Sample rate assumed when bounding the peak count of a file ffprobe can't rate */
+const WAVEFORM_FALLBACK_SAMPLE_RATE = 192000;
+
+/**
+ * Computes min/max peak pairs for drawing a waveform, by decoding the file
+ * to mono 16-bit PCM and scanning it in fixed-size windows as it streams in
+ * @param {string} audioFilePath - Audio file to analyze
+ * @param {Object} options - { samplesPerPeak } window size in samples (32-65536, default 1024),
+ *   or { width } number of peaks wanted (1-20000), from which the window size is derived
+ * @returns {Promise<Object>} - { sampleRate, samplesPerPeak, peaks } where peaks is [[min, max], ...] in -1..1
+ */
+export async function computeWaveformPeaks(audioFilePath, { samplesPerPeak: requestedSamplesPerPeak, width } = {}) {
+    if (requestedSamplesPerPeak !== undefined && width !== undefined) {
+        throw new Error('samplesPerPeak and width cannot be combined');
+    }
+    if (requestedSamplesPerPeak !== undefined &&
+        !(Number.isInteger(requestedSamplesPerPeak) && requestedSamplesPerPeak >= 32 && requestedSamplesPerPeak <= 65536)) {
+        throw new Error(`samplesPerPeak must be an integer between 32 and 65536: ${requestedSamplesPerPeak}`);
+    }
+    if (width !== undefined && !(Number.isInteger(width) && width >= 1 && width <= WAVEFORM_MAX_PEAKS)) {
+        throw new Error(`width must be an integer between 1 and ${WAVEFORM_MAX_PEAKS}: ${width}`);
+    }
+
+    // Headerless PCM can't be probed or decoded without knowing its format
+    const extension = path.extname(audioFilePath).slice(1);
+    if (Object.values(outputFormats).some(format => isRawPcm(format) && format.extension === extension)) {
+        const error = new Error(`Waveforms can't be computed for headerless PCM files: ${audioFilePath}`);
+        error.code = 'RAW_PCM';
+        throw error;
+    }
+
+    const probe = probeAudio(audioFilePath);
+    if (probe.duration > WAVEFORM_MAX_SECONDS) {
+        const error = new Error(`Waveforms are limited to ${WAVEFORM_MAX_SECONDS}s of audio; ${audioFilePath} is ${probe.duration}s long`);
+        error.code = 'WAVEFORM_TOO_LONG';
+        throw error;
+    }
+
+    // Bound the response by the most samples ffmpeg can hand back, not just what was asked for
+    const sampleRate = probe.sampleRate > 0 ? probe.sampleRate : WAVEFORM_FALLBACK_SAMPLE_RATE;
+    const totalSamples = Math.ceil((probe.duration > 0 ? probe.duration : WAVEFORM_MAX_SECONDS) * sampleRate);
+    const minSamplesPerPeak = Math.max(32, Math.ceil(totalSamples / WAVEFORM_MAX_PEAKS));
+    const samplesPerPeak = width !== undefined
+        ? Math.max(minSamplesPerPeak, Math.ceil(totalSamples / width))
+        : Math.max(minSamplesPerPeak, requestedSamplesPerPeak ?? 1024);
+
+    const peaks = [];
+    let min = 0;
+    let max = 0;
+    let windowSamples = 0;
+    let leftover = null;
+    const onStdout = chunk => {
+        const data = leftover ? Buffer.concat([leftover, chunk]) : chunk;
+        const end = data.length - (data.length % 2);
+        for (let offset = 0; offset < end; offset += 2) {
+            const sample = data.readInt16LE(offset);
+            if (sample < min) min = sample;
+            if (sample > max) max = sample;
+            if (++windowSamples === samplesPerPeak) {
+                peaks.push([Number((min / 32768).toFixed(4)), Number((max / 32767).toFixed(4))]);
+                min = 0;
+                max = 0;
+                windowSamples = 0;
+            }
+        }
+        leftover = end < data.length ? data.subarray(end) : null;
+    };
+
+    // -t also bounds inputs whose duration couldn't be probed
+    const { status, stderr, error } = await spawnFfmpeg(
+        ["-hide_banner", "-loglevel", "error", "-nostdin", "-i", audioFilePath, "-t", String(WAVEFORM_MAX_SECONDS), "-ac", "1", "-f", "s16le", "-"],
+        WAVEFORM_TIMEOUT_MS,
+        onStdout
+    );
+    if (error || status !== 0) {
+        throw new Error(`Failed to decode ${audioFilePath} for its waveform: ${error ? error.message : stderr.trim()}`);
+    }
+    if (windowSamples > 0) {
+        peaks.push([Number((min / 32768).toFixed(4)), Number((max / 32767).toFixed(4))]);
+    }
+    return { sampleRate: probe.sampleRate, samplesPerPeak, peaks };
+}
+
+/**
+ * stderr patterns from ffmpeg that indicate a transient I/O problem
+ * rather than a permanent failure such as a filter syntax error
+ */
+const RETRYABLE_FFMPEG_ERRORS = [
+    /Input\/output error/i,
+    /Resource temporarily unavailable/i,
+    /Stale file handle/i,
+    /Device or resource busy/i,
+    /Connection (reset|timed out)/i
+];
+
+/** Command run after each successful output; null when no hook is configured */
+let postProcessHook = null;
+
+/**
+ * Sets a command to run after every successful output, e.g. to copy it to
+ * a share or notify a queue. {output} in the arguments is replaced with the
+ * absolute output path.
+ * @param {Object|null} hook - { command, args, timeoutMs, failOnError }, or null to remove
+ */
+export function setPostProcessHook(hook) {
+    if (hook === null) {
+        postProcessHook = null;
+        return;
+    }
+
+    const {
+        command,
+        args = ['{output}'],
+        timeoutMs = 30000,
+        failOnError = false
+    } = hook;
+    if (typeof command !== 'string' || command.length === 0) {
+        throw new Error('Post-process hook needs a command');
+    }
+    if (!Array.isArray(args) || !args.every(arg => typeof arg === 'string')) {
+        throw new Error('Post-process hook args must be an array of strings');
+    }
+    if (!(Number.isInteger(timeoutMs) && timeoutMs > 0)) {
+        throw new Error(`Post-process hook timeout must be a positive integer: ${timeoutMs}`);
+    }
+    postProcessHook = { command, args, timeoutMs, failOnError };
+}
+
+/**
+ * Runs the configured post-process hook against an output file
+ * @param {string} outputFilePath - Absolute path of the finished output
+ * @returns {Promise<Object>} - { exitCode }, where exitCode is null if the hook could not run
+ */
+async function runPostProcessHook(outputFilePath) {
+    const { command, args, timeoutMs, failOnError } = postProcessHook;
+    const hookArgs = args.map(arg => arg.replaceAll('{output}', outputFilePath));
+
+    // No shell is involved, so the output path can't be interpreted as shell syntax
+    const hookRun = await spawnProcess(command, hookArgs, timeoutMs);
+    const exitCode = hookRun.status;
+    if (exitCode === 0) {
+        logger.log("Audio", `Post-process hook ${command} succeeded for ${outputFilePath}`);
+        return { exitCode };
+    }
+
+    const reason = hookRun.error ? hookRun.error.message : (hookRun.stderr ? hookRun.stderr.toString().trim() : '');
+    const message = `Post-process hook ${command} failed for ${outputFilePath} (exit ${exitCode})${reason ? `: ${reason}` : ''}`;
+    if (failOnError) {
+        throw new Error(message);
+    }
+    logger.warn("Audio", message);
+    return { exitCode };
+}
+
+/** Numeric limits setInputRules accepts */
+const INPUT_RULE_LIMITS = ['minSampleRate', 'maxSampleRate', 'minChannels', 'maxChannels', 'maxDuration'];
+
+/** Server-wide requirements inputs must meet before processing; null accepts everything */
+let inputRules = null;
+
+/**
+ * Sets the requirements every input is checked against, using ffprobe
+ * @param {Object|null} rules - { minSampleRate, maxSampleRate, minChannels, maxChannels, maxDuration, codecs }, or null to remove
+ */
+export function setInputRules(rules) {
+    if (rules === null) {
+        inputRules = null;
+        return;
+    }
+
+    const { codecs = null, ...limits } = rules;
+    for (const [name, value] of Object.entries(limits)) {
+        if (!INPUT_RULE_LIMITS.includes(name)) {
+            throw new Error(`Unknown input rule: ${name}`);
+        }
+        if (!(typeof value === 'number' && value > 0)) {
+            throw new Error(`Input rule ${name} must be a positive number: ${value}`);
+        }
+    }
+    if (codecs !== null && !(Array.isArray(codecs) && codecs.every(codec => typeof codec === 'string'))) {
+        throw new Error('Input rule codecs must be an array of codec names');
+    }
+    inputRules = { ...limits, codecs };
+}
+
+/**
+ * Rejects an input that breaks any of the configured input rules
+ * @param {string} inputFilePath - Input being checked, for the error message
+ * @param {Object|null} probe - probeAudio result, or null if probing failed
+ */
+function checkInputRules(inputFilePath, probe) {
+    if (!probe) {
+        throw new Error(`Input ${inputFilePath} was rejected: it could not be probed`);
+    }
+
+    const { minSampleRate, maxSampleRate, minChannels, maxChannels, maxDuration, codecs } = inputRules;
+    const violations = [];
+    if (minSampleRate && probe.sampleRate < minSampleRate) {
+        violations.push(`sample rate ${probe.sampleRate}Hz is below ${minSampleRate}Hz`);
+    }
+    if (maxSampleRate && probe.sampleRate > maxSampleRate) {
+        violations.push(`sample rate ${probe.sampleRate}Hz is above ${maxSampleRate}Hz`);
+    }
+    if (minChannels && probe.channels < minChannels) {
+        violations.push(`${probe.channels} channel(s) is fewer than ${minChannels}`);
+    }
+    if (maxChannels && probe.channels > maxChannels) {
+        violations.push(`${probe.channels} channels is more than ${maxChannels}`);
+    }
+    if (maxDuration && probe.duration > maxDuration) {
+        violations.push(`duration ${probe.duration}s is over ${maxDuration}s`);
+    }
+    if (codecs && !codecs.includes(probe.codec)) {
+        violations.push(`codec ${probe.codec} is not one of ${codecs.join(', ')}`);
+    }
+
+    if (violations.length > 0) {
+        throw new Error(`Input ${inputFilePath} was rejected: ${violations.join('; ')}`);
+    }
+}
+
+/** File each completed job's provenance record is appended to; null disables auditing */
+let auditLogPath = null;
+
+/**
+ * Sets the append-only audit log recording what each job did to its content
+ * @param {string|null} filePath - JSON lines file, or null to disable
+ */
+export function setAuditLogPath(filePath) {
+    if (filePath !== null) {
+        fs.ensureDirSync(path.dirname(path.resolve(filePath)));
+    }
+    auditLogPath = filePath;
+}
+
+/**
+ * Appends one job record to the audit log. A failed write fails the job,
+ * since a deliverable without provenance is what the log exists to prevent.
+ * @param {Object} record - Job details
+ */
+function writeAuditRecord(record) {
+    fs.appendFileSync(auditLogPath, JSON.stringify(record) + '\n');
+}
+
+/**
+ * Hashes a file's contents
+ * @param {string} filePath - File to hash
+ * @returns {string} - Hex sha256 digest
+ */
+function hashFile(filePath) {
+    return crypto.createHash('sha256').update(fs.readFileSync(filePath)).digest('hex');
+}
+
+/** Consecutive ffmpeg failure tracking; null leaves the breaker disabled */
+let circuitBreaker = null;
+
+/**
+ * Enables a circuit breaker that refuses processing for a cooldown once
+ * ffmpeg has failed several times in a row, so systemic failures (a missing
+ * codec, a broken binary) fail fast instead of piling up
+ * @param {Object|null} settings - { threshold, cooldownMs }, or null to disable
+ */
+export function setCircuitBreaker(settings) {
+    if (settings === null) {
+        circuitBreaker = null;
+        return;
+    }
+    const { threshold = 5, cooldownMs = 60000 } = settings;
+    if (!(Number.isInteger(threshold) && threshold >= 1)) {
+        throw new Error(`Circuit breaker threshold must be a positive integer: ${threshold}`);
+    }
+    if (!(Number.isInteger(cooldownMs) && cooldownMs >= 1000)) {
+        throw new Error(`Circuit breaker cooldown must be at least 1000ms: ${cooldownMs}`);
+    }
+    circuitBreaker = { threshold, cooldownMs, failures: 0, openUntil: 0 };
+}
+
+/**
+ * Reports whether the circuit breaker is currently refusing work
+ * @returns {Object|null} - { open, consecutiveFailures, retryAfterMs }, or null when disabled
+ */
+export function getCircuitBreakerState() {
+    if (!circuitBreaker) {
+        return null;
+    }
+    const retryAfterMs = Math.max(0, circuitBreaker.openUntil - Date.now());
+    return { open: retryAfterMs > 0, consecutiveFailures: circuitBreaker.failures, retryAfterMs };
+}
+
+/**
+ * Records the outcome of a processing run of ffmpeg against the breaker
+ * @param {boolean} succeeded - Whether ffmpeg succeeded
+ */
+function recordFfmpegOutcome(succeeded) {
+    if (!circuitBreaker) {
+        return;
+    }
+    if (succeeded) {
+        circuitBreaker.failures = 0;
+        return;
+    }
+    circuitBreaker.failures++;
+    // After the cooldown a single further failure reopens it straight away
+    if (circuitBreaker.failures >= circuitBreaker.threshold) {
+        circuitBreaker.openUntil = Date.now() + circuitBreaker.cooldownMs;
+        logger.error("Audio", `ffmpeg failed ${circuitBreaker.failures} times in a row; refusing processing for ${circuitBreaker.cooldownMs}ms`);
+    }
+}
+
+/** Scheduling priority ffmpeg is launched with via nice(1); null leaves it unchanged */
+let ffmpegNiceness = null;
+
+/**
+ * Sets the niceness every later ffmpeg run is launched with, so processing
+ * yields CPU to other services on a shared host
+ * @param {number|null} niceness - Integer from -20 to 19, or null to disable
+ */
+export function setFfmpegNiceness(niceness) {
+    if (niceness !== null && !(Number.isInteger(niceness) && niceness >= -20 && niceness <= 19)) {
+        throw new Error(`ffmpeg niceness must be an integer between -20 and 19: ${niceness}`);
+    }
+    if (niceness !== null && process.platform === 'win32') {
+        logger.warn("Audio", "nice is not available on Windows; ffmpeg will run at normal priority");
+        return;
+    }
+    ffmpegNiceness = niceness;
+}
+
+/**
+ * Resolves the command that launches ffmpeg, wrapping it in nice when configured
+ * @param {Array<string>} args - Arguments passed to ffmpeg
+ * @returns {Array} - [command, args] for spawn
+ */
+function getFfmpegCommand(args) {
+    if (ffmpegNiceness === null) {
+        return ["ffmpeg", args];
+    }
+    return ["nice", ["-n", String(ffmpegNiceness), "ffmpeg", ...args]];
+}
+
+/** Whether ffmpeg runs log verbosely by default, set from audio.debugLogging */
+let ffmpegDebugLogging = false;
+
+/**
+ * Switches ffmpeg's default log level to verbose for diagnosing filter chains
+ * @param {boolean} enabled - Whether to log verbosely
+ */
+export function setFfmpegDebugLogging(enabled) {
+    ffmpegDebugLogging = Boolean(enabled);
+}
+
+/** Log levels accepted by ffmpeg's -loglevel flag */
+const FFMPEG_LOG_LEVELS = ['quiet', 'panic', 'fatal', 'error', 'warning', 'info', 'verbose', 'debug', 'trace'];
+
+/** Log levels ffmpeg tags with [level] that strict mode treats as failures */
+const STRICT_FAILURE_LEVEL = /\[(warning|error|fatal|panic)\]\s*(.*)/;
+
+/**
+ * Runs ffmpeg once without blocking the event loop
+ * @param {Array<string>} ffmpegArgs - Arguments passed to ffmpeg
+ * @param {number} timeoutMs - Kill ffmpeg after this long (0 for no limit)
+ * @param {Function|null} onStdout - Called with each stdout chunk; stdout is discarded without it
+ * @returns {Promise<Object>} - { status, signal, stderr, error }
+ */
+function spawnFfmpeg(ffmpegArgs, timeoutMs = 0, onStdout = null) {
+    return spawnProcess(...getFfmpegCommand(ffmpegArgs), timeoutMs, onStdout);
+}
+
+/**
+ * Runs a command once without blocking the event loop. No shell is involved.
+ * @param {string} command - Executable to run
+ * @param {Array<string>} args - Arguments passed to it
+ * @param {number} timeoutMs - Kill the process after this long (0 for no limit)
+ * @param {Function|null} onStdout - Called with each stdout chunk; stdout is discarded without it
+ * @returns {Promise<Object>} - { status, signal, stderr, error }
+ */
+function spawnProcess(command, args, timeoutMs = 0, onStdout = null) {
+    return new Promise(resolve => {
+        const child = spawn(command, args, {
+            stdio: ['ignore', onStdout ? 'pipe' : 'ignore', 'pipe'] // Capture stderr only for errors and warnings
+        });
+        if (onStdout) {
+            child.stdout.on('data', onStdout);
+        }
+        const chunks = [];
+        // Settle on the timeout itself rather than waiting for the pipes to close
+        const timer = timeoutMs > 0
+            ? setTimeout(() => {
+                child.kill('SIGKILL');
+                child.stderr.destroy();
+                if (child.stdout) {
+                    child.stdout.destroy();
+                }
+                const error = new Error(`${command} did not finish within ${timeoutMs}ms`);
+                resolve({ status: null, signal: 'SIGKILL', stderr: Buffer.concat(chunks).toString(), error });
+            }, timeoutMs)
+            : null;
+        child.stderr.on('data', chunk => chunks.push(chunk));
+        child.on('error', error => {
+            clearTimeout(timer);
+            resolve({ status: null, signal: null, stderr: '', error });
+        });
+        child.on('close', (status, signal) => {
+            clearTimeout(timer);
+            resolve({ status, signal, stderr: Buffer.concat(chunks).toString(), error: null });
+        });
+    });
+}
+
+/**
+ * Runs ffmpeg, retrying with exponential backoff when it fails with a
+ * transient I/O error
+ * @param {Array<string>} args - Arguments passed to ffmpeg
+ * @param {Object} options - { retries, retryDelayMs, logLevel, collectWarnings, timeoutMs }
+ * @returns {Promise<Array<string>>} - Warnings ffmpeg logged, when collectWarnings is set
+ */
+async function runFfmpeg(args, { retries = 0, retryDelayMs = 500, logLevel, collectWarnings = false, timeoutMs = 0 } = {}) {
+    // Keep stderr to real problems unless audio debugging is switched on
+    const defaultLevel = collectWarnings ? 'warning' : 'error';
+    const level = logLevel || (ffmpegDebugLogging ? 'verbose' : defaultLevel);
+    if (!FFMPEG_LOG_LEVELS.includes(level)) {
+        throw new Error(`Invalid ffmpeg log level: ${level}`);
+    }
+    if (collectWarnings && FFMPEG_LOG_LEVELS.indexOf(level) < FFMPEG_LOG_LEVELS.indexOf('warning')) {
+        throw new Error(`Log level ${level} hides the warnings strict mode checks for`);
+    }
+
+    // Tag each line with its level so warnings can be told apart from verbose output
+    const ffmpegArgs = ["-hide_banner", "-loglevel", collectWarnings ? `level+${level}` : level, ...args];
+    logger.debug("Audio", `Executing command: ffmpeg ${ffmpegArgs.join(' ')}`);
+
+    for (let attempt = 0; ; attempt++) {
+        const run = await spawnFfmpeg(ffmpegArgs, timeoutMs);
+        if (!run.error && run.status === 0) {
+            if (!collectWarnings) {
+                return [];
+            }
+            return run.stderr.split('\n')
+                .map(line => line.match(STRICT_FAILURE_LEVEL))
+                .filter(Boolean)
+                .map(match => match[2].trim());
+        }
+
+        const retryable = RETRYABLE_FFMPEG_ERRORS.some(pattern => pattern.test(run.stderr));
+        if (!retryable || attempt >= retries) {
+            const error = run.error || new Error(`ffmpeg exited with ${run.signal || `code ${run.status}`}: ${run.stderr.trim()}`);
+            error.stderr = run.stderr;
+            throw error;
+        }
+
+        const delay = retryDelayMs * 2 ** attempt;
+        logger.warn("Audio", `ffmpeg failed with a transient error, retrying in ${delay}ms (${attempt + 1}/${retries})`);
+        await new Promise(resolve => setTimeout(resolve, delay));
+    }
+}
+
+/** Named bundles of processing options, selected with the profile option */
+const processingProfiles = {};
+
+/**
+ * Adds or replaces a named processing profile
+ * @param {string} name - Profile name used by the profile option
+ * @param {Object} profileOptions - Processing options the profile applies
+ */
+export function registerProcessingProfile(name, profileOptions) {
+    if (!profileOptions || typeof profileOptions !== 'object' || Array.isArray(profileOptions)) {
+        throw new Error(`Processing profile ${name} must be an object of options`);
+    }
+    if ('profile' in profileOptions) {
+        throw new Error(`Processing profile ${name} cannot reference another profile`);
+    }
+    processingProfiles[name] = { ...profileOptions };
+}
+
+/**
+ * Merges the selected profile's options under the explicitly given ones
+ * @param {Object} options - Processing options, optionally naming a profile
+ * @returns {Object} - Options with the profile applied
+ */
+function applyProcessingProfile(options) {
+    const { profile, ...overrides } = options;
+    if (!profile) {
+        return options;
+    }
+
+    const profileOptions = processingProfiles[profile];
+    if (!profileOptions) {
+        throw new Error(`Unknown processing profile: ${profile}`);
+    }
+    return { ...profileOptions, ...overrides };
+}
+
+/**
+ * Checks the sampleRates option
+ * @param {Array<number>} sampleRates - Output sample rates in Hz
+ * @returns {Array<number>} - The same rates, validated
+ */
+function validateSampleRates(sampleRates) {
+    if (!Array.isArray(sampleRates) || sampleRates.length === 0 || sampleRates.length > MAX_SAMPLE_RATE_OUTPUTS) {
+        throw new Error(`sampleRates must list between 1 and ${MAX_SAMPLE_RATE_OUTPUTS} rates`);
+    }
+    if (!sampleRates.every(rate => Number.isInteger(rate) && rate >= 8000 && rate <= 192000)) {
+        throw new Error(`Sample rates must be integers between 8000 and 192000: ${sampleRates.join(', ')}`);
+    }
+    if (new Set(sampleRates).size !== sampleRates.length) {
+        throw new Error(`sampleRates contains duplicates: ${sampleRates.join(', ')}`);
+    }
+    return sampleRates;
+}
+
+/**
+ * Builds the graph section that splits the processed [out] stream into one
+ * resampled stream per rate, labelled [o0], [o1], ...
+ * @param {Array<number>} sampleRates - Output sample rates in Hz
+ * @returns {string} - filter_complex section to append after the main graph
+ */
+function buildResampleSplit(sampleRates) {
+    const splitLabels = sampleRates.map((_, index) => `[r${index}]`).join('');
+    return [
+        `[out]asplit=${sampleRates.length}${splitLabels}`,
+        ...sampleRates.map((rate, index) => `[r${index}]aresample=${rate}[o${index}]`)
+    ].join(';');
+}
+
+/**
+ * Checks that channelFilters holds one array of filter strings per stereo channel
+ * @param {*} channelFilters - Value of the channelFilters option
+ * @returns {Array<Array<string>>} - [left filters, right filters]
+ */
+function validateChannelFilters(channelFilters) {
+    if (!Array.isArray(channelFilters) || channelFilters.length !== 2 ||
+        !channelFilters.every(chain => Array.isArray(chain) && chain.every(filter => typeof filter === 'string'))) {
+        throw new Error('channelFilters must be two arrays of filter strings, one per stereo channel');
+    }
+    return channelFilters;
+}
+
+/**
+ * Builds a filtergraph that runs separate filters on the left and right
+ * channels of a stereo input, rejoins them and then applies the main chain
+ * @param {Array<Array<string>>} channelFilters - [left filters, right filters], already validated
+ * @param {string} mainChain - Comma-joined chain applied after rejoining
+ * @returns {string} - Filtergraph for -filter_complex with an [out] label
+ */
+function buildChannelSplitGraph(channelFilters, mainChain) {
+    const [left, right] = channelFilters.map(chain => chain.length > 0 ? chain.join(',') : 'anull');
+    return [
+        '[0:a]channelsplit=channel_layout=stereo[left][right]',
+        `[left]${left}[l]`,
+        `[right]${right}[r]`,
+        '[l][r]join=inputs=2:channel_layout=stereo[joined]',
+        `[joined]${mainChain || 'anull'}[out]`
+    ].join(';');
+}
+
+/**
+ * Resolves an impulse response file inside the allowed directory
+ * @param {string} irFile - Impulse response path, relative to irDir
+ * @param {string} irDir - Directory impulse responses must live in
+ * @returns {string} - Absolute path to the impulse response
+ */
+function resolveImpulseResponse(irFile, irDir) {
+    // Prevent path traversal out of the impulse response directory
+    const irPath = resolveContainedPath(irDir, irFile);
+    if (!irPath) {
+        throw new Error(`Impulse response must be inside ${irDir}: ${irFile}`);
+    }
+    if (!fs.existsSync(irPath)) {
+        throw new Error(`Impulse response not found: ${irFile}`);
+    }
+    return irPath;
+}
+
+/**
+ * Builds a filtergraph that feeds the impulse response (second input) into
+ * the chain's afir stage, splitting the chain around it
+ * @param {Array<string>} filters - Filter chain containing one afir stage
+ * @param {number} sampleRate - Sample rate of the main input
+ * @returns {string} - filter_complex graph ending in [out]
+ */
+function buildConvolutionGraph(filters, sampleRate) {
+    const index = filters.findIndex(filter => filter.startsWith('afir='));
+    const before = filters.slice(0, index).join(',') || 'anull';
+    const after = filters.slice(index + 1).join(',') || 'anull';
+    return [
+        `[0:a]${before}[dry]`,
+        `[1:a]aresample=${sampleRate}[ir]`,
+        `[dry][ir]${filters[index]}[wet]`,
+        `[wet]${after}[out]`
+    ].join(';');
+}
+
+/**
+ * Builds the ffmpeg filter chain for a set of processing options
+ * @param {Object} options - Processing options
+ * @returns {Array<string>} - Ordered array of filter strings
+ */
+function buildFilterChain(options) {
+    const {
+        enhanceVocals = true,
+        preset = 'clarity',
+        presets = null,
+        measured = null,
+        skipCompand = false,
+        skipLoudnorm = false,
+        eqFile = null,
+        eqDir = 'resources/eq',
+        gate = null,
+        truePeakLimit = false,
+        truePeakCeiling = -1.0,
+        channelLayout = null,
+        disableLra = false,
+        postGain = 0,
+        declip = false,
+        reverb = null,
+        minDuration = null,
+        chainId = null,
+        shortInput = false,
+        impulseResponse = null,
+        highPrecision = false,
+        balance = 0,
+        channelGains = null,
+        dualMono = false,
+        linear = null,
+        expander = null,
+        telephone = null,
+        targetLufs = null
+    } = options;
+
+    let filters = [];
+    if (chainId) {
+        // A stored chain stands in for the preset entirely
+        filters = resolveChain(chainId);
+    } else if (enhanceVocals) {
+        filters = presets ? getChainedPresetFilters(presets) : getPresetFilters(preset);
+    }
+    if (telephone) {
+        filters = customizeTelephone(filters, telephone);
+    }
+    if (eqFile) {
+        filters = insertBeforeDynamics(filters, loadEqProfile(eqFile, eqDir));
+    }
+    if (impulseResponse) {
+        // Convolve before dynamics, like EQ, since mic and room matching are tonal corrections
+        const { mix = 1 } = typeof impulseResponse === 'object' ? impulseResponse : {};
+        if (!(mix > 0 && mix <= 1)) {
+            throw new Error(`Impulse response mix must be between 0 and 1: ${mix}`);
+        }
+        filters = insertBeforeDynamics(filters, [`afir=dry=${1 - mix}:wet=${mix}`]);
+    }
+    if (skipCompand) {
+        filters = filters.filter(filter => !filter.startsWith('compand='));
+    }
+    if (skipLoudnorm) {
+        filters = filters.filter(filter => !filter.startsWith('loudnorm='));
+    }
+    if (expander) {
+        // After skipCompand, which would otherwise strip the expander's own compand stage
+        filters = insertBeforeDynamics(filters, [buildExpanderFilter(expander === true ? {} : expander)]);
+    }
+    if (disableLra) {
+        // loudnorm always targets some LRA; 50 is its widest, leaving dynamics alone
+        filters = filters.map(filter =>
+            filter.startsWith('loudnorm=') ? filter.replace(/:LRA=[^:]*/, '').concat(':LRA=50') : filter
+        );
+    }
+    if (targetLufs !== null) {
+        if (!(targetLufs >= -70 && targetLufs <= -5)) {
+            throw new Error(`Target loudness must be between -70 and -5 LUFS: ${targetLufs}`);
+        }
+        filters = filters.map(filter => {
+            if (!filter.startsWith('loudnorm=')) {
+                return filter;
+            }
+            const params = filter.slice('loudnorm='.length).split(':').filter(param => !param.startsWith('I='));
+            return `loudnorm=${[`I=${targetLufs}`, ...params].join(':')}`;
         });
-        
-        // Check that the output file exists
-        if (!fs.existsSync(outputFilePath)) {
-            throw new Error(`Output file was not created: ${outputFilePath}`);
+    }
+    if (reverb) {
+        // Ahead of loudnorm so the added ambience is part of what gets normalized
+        const reverbFilter = buildReverbFilter(typeof reverb === 'object' ? reverb : { room: reverb === true ? undefined : reverb });
+        const loudnormIndex = filters.findIndex(filter => filter.startsWith('loudnorm='));
+        filters = loudnormIndex === -1
+            ? [...filters, reverbFilter]
+            : [...filters.slice(0, loudnormIndex), reverbFilter, ...filters.slice(loudnormIndex)];
+    }
+    if (shortInput) {
+        if (measured || linear !== null) {
+            throw new Error('shortInput replaces loudnorm, so it cannot be combined with measured or linear');
         }
-        
-        logger.log("Audio", `Successfully processed audio to ${outputFilePath}`);
-        return `/${outputFileName}`;
-    } catch (error) {
-        logger.error("Audio", `Error processing audio: ${error.message}`);
-        throw error; // Re-throw to let caller handle it
+        filters = filters.map(filter => filter.startsWith('loudnorm=') ? buildShortInputNormalizer(filter) : filter);
+    }
+    if (measured) {
+        filters = applyMeasuredLoudness(filters, measured);
+    }
+    if (linear === true && !measured) {
+        // Without measured values loudnorm quietly falls back to dynamic mode
+        throw new Error('linear loudnorm needs measured loudness values');
+    }
+    if (dualMono || linear !== null) {
+        filters = filters.map(filter => {
+            if (!filter.startsWith('loudnorm=')) {
+                return filter;
+            }
+            let updated = filter.replace(/:(linear|dual_mono)=[^:]*/g, '');
+            if (linear !== null) {
+                updated += `:linear=${Boolean(linear)}`;
+            }
+            // Mono is played back on two speakers, so measure it as it will be heard
+            return dualMono ? `${updated}:dual_mono=true` : updated;
+        });
+    }
+    const ceilingLimiter = buildLimiterFilter(truePeakCeiling);
+    filters = filters.map(filter =>
+        filter === SAFETY_LIMITER ? ceilingLimiter : filter
+    );
+    if (truePeakLimit) {
+        filters = [...filters, ceilingLimiter];
+    }
+    if (postGain) {
+        if (!(postGain >= -30 && postGain <= 30)) {
+            throw new Error(`Post gain must be between -30 and 30 dB: ${postGain}`);
+        }
+        // Trim ahead of the ceiling limiter so a boost can't push peaks back over it
+        const limiterIndex = filters.indexOf(ceilingLimiter);
+        const trimIndex = limiterIndex === -1 ? filters.length : limiterIndex;
+        filters = [...filters.slice(0, trimIndex), `volume=${postGain}dB`, ...filters.slice(trimIndex)];
+    }
+    // alimiter holds sample peaks; run it oversampled so inter-sample peaks stay under the ceiling too
+    filters = filters.flatMap(filter =>
+        filter === ceilingLimiter ? [`aresample=${TRUE_PEAK_OVERSAMPLE_RATE}`, ceilingLimiter] : [filter]
+    );
+    if (minDuration !== null) {
+        if (!(minDuration > 0 && minDuration <= MAX_MIN_DURATION)) {
+            throw new Error(`Minimum duration must be between 0 and ${MAX_MIN_DURATION} seconds: ${minDuration}`);
+        }
+        // Pad last so the silence doesn't pull down loudnorm's measurement
+        filters = [...filters, `apad=whole_dur=${minDuration}`];
+    }
+    if (gate) {
+        // Gate first so compand can't lift the noise between phrases back up
+        filters = [buildGateFilter(gate === true ? {} : gate), ...filters];
+    }
+    if (balance) {
+        if (channelLayout !== 'stereo') {
+            throw new Error('balance requires stereo output (channelLayout: stereo)');
+        }
+        if (!(balance >= -1 && balance <= 1)) {
+            throw new Error(`Balance must be between -1 (left) and 1 (right): ${balance}`);
+        }
+        // Correct the image before anything level-dependent sees the lopsided channel
+        filters = [`stereotools=balance_out=${balance}`, ...filters];
+    }
+    if (channelGains) {
+        filters = [buildChannelGainFilter(channelGains, channelLayout), ...filters];
+    }
+    if (declip) {
+        filters = [...DECLIP_FILTERS, ...filters];
+    }
+    if (channelLayout) {
+        // Convert up front so ffmpeg's layout-aware downmix runs before any processing
+        getChannelCount(channelLayout);
+        filters = [`aformat=channel_layouts=${channelLayout}`, ...filters];
+    }
+    if (highPrecision) {
+        // Formats are negotiated between neighbours, so every later filter that accepts doubles keeps them
+        filters = ['aformat=sample_fmts=dbl', ...filters];
+    }
+
+    const limitError = checkFilterChainLimits(filters);
+    if (limitError) {
+        throw new Error(limitError);
+    }
+    return filters;
+}
+
+/**
+ * Builds a pan filter applying an independent gain to each stereo channel
+ * @param {Object} channelGains - { left, right } gains in dB (default 0)
+ * @param {string|null} channelLayout - Output channel layout; must be stereo
+ * @returns {string} - pan filter string
+ */
+function buildChannelGainFilter(channelGains, channelLayout) {
+    if (channelLayout !== 'stereo') {
+        throw new Error('channelGains requires stereo output (channelLayout: stereo)');
+    }
+    const unknown = Object.keys(channelGains).filter(channel => channel !== 'left' && channel !== 'right');
+    if (unknown.length > 0) {
+        throw new Error(`Unknown channels for a stereo layout: ${unknown.join(', ')}`);
+    }
+    const { left = 0, right = 0 } = channelGains;
+    for (const [channel, gain] of [['left', left], ['right', right]]) {
+        if (!(gain >= -30 && gain <= 30)) {
+            throw new Error(`${channel} channel gain must be between -30 and 30 dB: ${gain}`);
+        }
+    }
+    // pan takes linear factors, not dB
+    const factor = gain => Number(Math.pow(10, gain / 20).toFixed(6));
+    return `pan=stereo|c0=${factor(left)}*c0|c1=${factor(right)}*c1`;
+}
+
+/**
+ * Creates a chain resolver that reads named chains from JSON files, each
+ * holding an array of filter strings (or { filters: [...] })
+ * @param {string} chainDir - Directory holding <chainId>.json files
+ * @returns {Function} - Resolver mapping a chain ID to its filters
+ */
+export function createDirectoryChainResolver(chainDir) {
+    return (chainId) => {
+        const chainPath = path.resolve(process.cwd(), chainDir, `${chainId}.json`);
+        if (!fs.existsSync(chainPath)) {
+            return null;
+        }
+        const chain = fs.readJSONSync(chainPath);
+        return Array.isArray(chain) ? chain : chain.filters;
+    };
+}
+
+/** Looks up stored filter chains for the chainId option */
+let chainResolver = createDirectoryChainResolver('resources/chains');
+
+/**
+ * Replaces the chain resolver, e.g. with one backed by a database
+ * @param {Function} resolver - Maps a chain ID to an array of filter strings, or null if unknown
+ */
+export function setChainResolver(resolver) {
+    if (typeof resolver !== 'function') {
+        throw new Error('Chain resolver must be a function');
+    }
+    chainResolver = resolver;
+}
+
+/**
+ * Resolves a chain ID to its filters through the current resolver
+ * @param {string} chainId - Stored chain name
+ * @returns {Array<string>} - Array of filter strings
+ */
+function resolveChain(chainId) {
+    // IDs become filenames in the default resolver, so keep them to a safe alphabet
+    if (!/^[\w.-]+$/.test(chainId) || chainId.startsWith('.')) {
+        throw new Error(`Invalid chain ID: ${chainId}`);
+    }
+
+    const filters = chainResolver(chainId);
+    if (!filters) {
+        throw new Error(`Unknown filter chain: ${chainId}`);
+    }
+    if (!Array.isArray(filters) || !filters.every(filter => typeof filter === 'string' && filter.length > 0)) {
+        throw new Error(`Filter chain ${chainId} is not a list of filter strings`);
+    }
+    return filters;
+}
+
+/** Upper bound, in seconds, for the minDuration option */
+const MAX_MIN_DURATION = 3600;
+
+/** Caps on chain size, set server-side so requests can't raise their own */
+let filterChainLimits = { maxFilters: 64, maxFilterLength: 8192 };
+
+/**
+ * Sets the size limits every filter chain is checked against
+ * @param {Object} limits - { maxFilters, maxFilterLength } (defaults 64 and 8192)
+ */
+export function setFilterChainLimits({ maxFilters = 64, maxFilterLength = 8192 } = {}) {
+    if (!(Number.isInteger(maxFilters) && maxFilters >= 1)) {
+        throw new Error(`maxFilters must be a positive integer: ${maxFilters}`);
+    }
+    if (!(Number.isInteger(maxFilterLength) && maxFilterLength >= 1)) {
+        throw new Error(`maxFilterLength must be a positive integer: ${maxFilterLength}`);
     }
+    filterChainLimits = { maxFilters, maxFilterLength };
+}
+
+/**
+ * Checks a filter chain against the configured size limits so an oversized
+ * chain can't build an enormous filtergraph
+ * @param {Array<string>} filters - Filter strings to check
+ * @returns {string|null} - Error message, or null when within limits
+ */
+export function checkFilterChainLimits(filters) {
+    const { maxFilters, maxFilterLength } = filterChainLimits;
+    // One entry may hold several comma-separated filters, so count what ffmpeg will build
+    const filterCount = splitFilterChain(filters).length;
+    if (filterCount > maxFilters) {
+        return `Filter chain has ${filterCount} filters; the limit is ${maxFilters}`;
+    }
+
+    const totalLength = filters.reduce((total, filter) => total + filter.length, 0);
+    if (totalLength > maxFilterLength) {
+        return `Filter chain is ${totalLength} characters long; the limit is ${maxFilterLength}`;
+    }
+    return null;
+}
+
+/**
+ * Splits filter strings into single filters at the commas and semicolons
+ * ffmpeg separates them with, leaving quoted, escaped and [label] ones alone
+ * @param {Array<string>} filters - Filter strings, each possibly a chain itself
+ * @returns {Array<string>} - One trimmed entry per filter
+ */
+function splitFilterChain(filters) {
+    const split = [];
+    for (const chain of filters) {
+        let current = '';
+        let quoted = false;
+        let bracketDepth = 0;
+        for (let index = 0; index < chain.length; index++) {
+            const char = chain[index];
+            if (char === '\\' && index + 1 < chain.length) {
+                current += char + chain[++index];
+                continue;
+            }
+            if (char === "'") {
+                quoted = !quoted;
+            } else if (!quoted && char === '[') {
+                bracketDepth++;
+            } else if (!quoted && char === ']') {
+                bracketDepth = Math.max(0, bracketDepth - 1);
+            } else if (!quoted && bracketDepth === 0 && (char === ',' || char === ';')) {
+                split.push(current.trim());
+                current = '';
+                continue;
+            }
+            current += char;
+        }
+        split.push(current.trim());
+    }
+    return split;
+}
+
+/** Supported output channel layouts and their channel counts */
+const CHANNEL_LAYOUTS = {
+    mono: 1,
+    stereo: 2,
+    '2.1': 3,
+    quad: 4,
+    '5.0': 5,
+    '5.1': 6,
+    '7.1': 8
+};
+
+/**
+ * Gets the channel count for a supported channel layout
+ * @param {string} layout - Channel layout name
+ * @returns {number} - Number of channels
+ */
+function getChannelCount(layout) {
+    const count = CHANNEL_LAYOUTS[layout];
+    if (!count) {
+        throw new Error(`Unsupported channel layout: ${layout}`);
+    }
+    return count;
 }
 
 /**
  * Process multiple audio files in sequence
  * @param {Array<string>} inputFiles - Array of input file paths
  * @param {Object} options - Processing options
- * @returns {Array<string>} - Array of processed file paths
+ * @returns {Promise<Array<string>>} - Array of processed file paths
  */
-export function batchProcessAudio(inputFiles, options = {}) {
+export async function batchProcessAudio(inputFiles, options = {}) {
     const results = [];
     for (const file of inputFiles) {
-        const outputPath = processAudio(file, options);
+        const outputPath = await processAudio(file, options);
         results.push(outputPath);
     }
     return results;
 }
 
+/**
+ * Renders one output per preset from the same input, for comparing
+ * presets side by side
+ * @param {string} inputFilePath - Path to the input WAV file
+ * @param {Array<string>} presetNames - Presets to render; each must exist
+ * @param {Object} options - Processing options shared by every render
+ * @returns {Promise<Array<Object>>} - [{ preset, outputPath }] in the order requested
+ */
+export async function renderPresetVariants(inputFilePath, presetNames, options = {}) {
+    const unknown = presetNames.filter(name => !basePresets[name]);
+    if (unknown.length > 0) {
+        throw new Error(`Unknown preset(s): ${unknown.join(', ')}`);
+    }
+
+    const { presets, inputSha256 = null, ...variantOptions } = options;
+    // Check and hash the original once rather than every variant's input
+    const inputHash = inputSha256 || auditLogPath ? hashFile(inputFilePath) : null;
+    if (inputSha256) {
+        if (inputHash !== inputSha256.toLowerCase()) {
+            throw new Error(`Input checksum mismatch for ${inputFilePath}: expected ${inputSha256}, got ${inputHash}`);
+        }
+    }
+
+    // The rules apply to the original: the decoded copy is always pcm_f32le, and
+    // an over-long input shouldn't be decoded at all
+    const probe = tryProbeAudio(inputFilePath);
+    if (inputRules) {
+        checkInputRules(inputFilePath, probe);
+    }
+
+    // Decode compressed input once instead of once per preset. The copy keeps
+    // the input's basename so output names are unchanged.
+    let decodedDirectory = null;
+    let sourceFilePath = inputFilePath;
+    if (probe && !probe.codec.startsWith('pcm_')) {
+        decodedDirectory = path.join(os.tmpdir(), `enspira_${crypto.randomUUID()}`);
+        fs.ensureDirSync(decodedDirectory);
+        sourceFilePath = path.join(decodedDirectory, `${path.basename(inputFilePath, path.extname(inputFilePath))}.wav`);
+        await runFfmpeg(["-nostdin", "-y", "-i", inputFilePath, "-c:a", "pcm_f32le", sourceFilePath], {
+            retries: variantOptions.retries,
+            retryDelayMs: variantOptions.retryDelayMs,
+            logLevel: variantOptions.logLevel
+        });
+    }
+
+    try {
+        const variants = [];
+        // A later variant's disk budget check mustn't evict an earlier one
+        const outputDirectory = path.resolve(process.cwd(), variantOptions.outputDir || 'final');
+        const writtenFilePaths = [];
+        for (const preset of new Set(presetNames)) {
+            // Suffix each output so the variants don't overwrite one another
+            const { outputPath, outputs, spectrogram } = await processAudioDetailed(
+                sourceFilePath,
+                { ...variantOptions, preset, outputSuffix: `_${preset}` },
+                { path: inputFilePath, sha256: inputHash },
+                writtenFilePaths
+            );
+            const written = [...(outputs || [{ outputPath }]).map(output => output.outputPath), ...(spectrogram ? [spectrogram] : [])];
+            writtenFilePaths.push(...written.map(filePath => path.join(outputDirectory, filePath)));
+            variants.push({ preset, outputPath });
+        }
+        return variants;
+    } finally {
+        if (decodedDirectory) {
+            fs.removeSync(decodedDirectory);
+        }
+    }
+}
+
+/**
+ * Rate the ceiling limiter runs at, 4x oversampled for 48kHz input as
+ * BS.1770 true-peak metering is, and the rate loudnorm already leaves
+ */
+const TRUE_PEAK_OVERSAMPLE_RATE = 192000;
+
+/** Limiter stage of the safety preset, swapped for the requested ceiling at build time */
+const SAFETY_LIMITER = buildLimiterFilter(-1.0);
+
+/** Presets that deliberately color the voice rather than correct it */
+const CREATIVE_PRESETS = new Set(['telephone']);
+
+/**
+ * Builds the stages of the telephone effect: a lo-fi handset voice with a
+ * narrow band, a little bit reduction and line hiss
+ * @param {Object} options - Effect options
+ * @param {number} options.lowCut - Bottom of the phone band in Hz (default 300)
+ * @param {number} options.highCut - Top of the phone band in Hz (default 3400)
+ * @param {number|null} options.bits - Bit depth to crush to (default 8; null disables)
+ * @param {number|null} options.noise - Line hiss level in dBFS (default -50; null disables)
+ * @returns {Array<Object>} - Preset stages as { filter, description }
+ */
+function buildTelephoneStages({ lowCut = 300, highCut = 3400, bits = 8, noise = -50 } = {}) {
+    if (!(lowCut >= 20 && highCut <= 20000 && lowCut < highCut)) {
+        throw new Error(`Telephone band must satisfy 20 <= lowCut < highCut <= 20000 Hz: ${lowCut}-${highCut}`);
+    }
+    if (bits !== null && !(Number.isInteger(bits) && bits >= 2 && bits <= 16)) {
+        throw new Error(`Telephone bits must be an integer between 2 and 16: ${bits}`);
+    }
+    if (noise !== null && !(noise >= -90 && noise <= -20)) {
+        throw new Error(`Telephone noise must be between -90 and -20 dBFS: ${noise}`);
+    }
+
+    const stages = [];
+    if (bits !== null) {
+        // Crush ahead of the band so the distortion's harmonics are filtered like the voice
+        stages.push({ filter: `acrusher=bits=${bits}:mode=log:mix=0.3`, description: `Adds slight ${bits}-bit grit` });
+    }
+    stages.push(
+        { filter: `highpass=f=${lowCut}`, description: `Cuts everything below the ${lowCut}Hz phone band` },
+        { filter: `lowpass=f=${highCut}`, description: `Cuts everything above the ${highCut}Hz phone band` },
+        { filter: 'equalizer=f=1000:width_type=o:width=0.7:g=4', description: 'Adds the nasal midrange honk of a handset' }
+    );
+    if (noise !== null) {
+        const amplitude = Number(Math.pow(10, noise / 20).toFixed(6));
+        stages.push({ filter: `aeval=val(ch)+${amplitude}*(2*random(0)-1):c=same`, description: `Mixes in line hiss at ${noise} dBFS` });
+    }
+    stages.push(
+        { filter: 'compand=0.1|0.1:1|1:-90/-40|-40/-20|-20/-10|-10/-5:3:0:-90:0.1', description: 'Squashes dynamics like a phone line' },
+        { filter: 'loudnorm=I=-14:TP=-3:LRA=5', description: 'Normalizes to -14 LUFS with a narrow loudness range' }
+    );
+    return stages;
+}
+
+/**
+ * Built-in presets. Each stage is either a raw ffmpeg filter string or an
+ * object pairing the filter with a human-readable description.
+ */
+const basePresets = {
+    clarity: [
+        { filter: 'highpass=f=150', description: 'Removes rumble below 150Hz' },
+        { filter: 'lowpass=f=11000', description: 'Rolls off hiss above 11kHz' },
+        { filter: 'equalizer=f=250:width_type=o:width=1:g=0.5', description: 'Adds a touch of body around 250Hz' },
+        { filter: 'equalizer=f=2500:width_type=o:width=1:g=1.5', description: 'Lifts speech presence around 2.5kHz' },
+        { filter: 'equalizer=f=4000:width_type=q:width=20:g=-2', description: 'Notches a narrow band of harshness at 4kHz' },
+        { filter: 'equalizer=f=6000:width_type=o:width=1:g=-1', description: 'Softens sibilance around 6kHz' },
+        { filter: 'compand=0.2|0.3:1|1:-90/-60|-60/-40|-40/-30|-20/-20:6:0:-90:0.2', description: 'Gently evens out quiet and loud passages' },
+        { filter: 'loudnorm=I=-16:TP=-1.5:LRA=11', description: 'Normalizes to -16 LUFS with a -1.5 dBTP ceiling' }
+    ],
+    warmVocal: [
+        { filter: 'highpass=f=100', description: 'Removes rumble below 100Hz' },
+        { filter: 'lowpass=f=11000', description: 'Rolls off hiss above 11kHz' },
+        { filter: 'equalizer=f=200:width_type=o:width=1:g=2', description: 'Boosts warmth around 200Hz' },
+        { filter: 'equalizer=f=600:width_type=o:width=1:g=1', description: 'Fills out the lower mids around 600Hz' },
+        { filter: 'equalizer=f=3000:width_type=o:width=1.5:g=1', description: 'Keeps words intelligible around 3kHz' },
+        { filter: 'equalizer=f=4000:width_type=q:width=20:g=-2', description: 'Notches a narrow band of harshness at 4kHz' },
+        { filter: 'compand=0.2|0.3:1|1:-90/-60|-60/-40|-40/-30|-20/-15:5:0:-90:0.3', description: 'Compresses loud peaks for a smoother level' },
+        { filter: 'loudnorm=I=-16:TP=-1.5:LRA=10', description: 'Normalizes to -16 LUFS with a -1.5 dBTP ceiling' }
+    ],
+    brightVocal: [
+        { filter: 'highpass=f=130', description: 'Removes rumble below 130Hz' },
+        { filter: 'lowpass=f=12000', description: 'Rolls off hiss above 12kHz' },
+        { filter: 'equalizer=f=3000:width_type=o:width=1:g=2', description: 'Boosts presence around 3kHz' },
+        { filter: 'equalizer=f=4000:width_type=q:width=20:g=-2', description: 'Notches a narrow band of harshness at 4kHz' },
+        { filter: 'equalizer=f=6000:width_type=o:width=1:g=0.5', description: 'Adds a little air around 6kHz' },
+        { filter: 'equalizer=f=200:width_type=o:width=1:g=-1', description: 'Thins out muddiness around 200Hz' },
+        { filter: 'compand=0.1|0.2:1|1:-90/-60|-60/-40|-40/-30|-20/-15:5:0:-90:0.1', description: 'Fast compression that keeps the voice forward' },
+        { filter: 'loudnorm=I=-14:TP=-1.5:LRA=6', description: 'Normalizes to a louder -14 LUFS with a tight loudness range' }
+    ],
+    smoothVocal: [
+        { filter: 'highpass=f=80', description: 'Removes rumble below 80Hz' },
+        { filter: 'lowpass=f=11000', description: 'Rolls off hiss above 11kHz' },
+        { filter: 'highshelf=f=6000:g=-6', description: 'Tames everything above 6kHz for a softer top end' },
+        { filter: 'equalizer=f=2000:width_type=o:width=1:g=1', description: 'Keeps words intelligible around 2kHz' },
+        { filter: 'equalizer=f=4000:width_type=q:width=20:g=-2', description: 'Notches a narrow band of harshness at 4kHz' },
+        { filter: 'compand=0.3|0.5:1|1:-90/-60|-60/-40|-40/-30|-20/-15:4:0:-90:0.5', description: 'Slow compression for a relaxed level' },
+        { filter: 'loudnorm=I=-16:TP=-1.5:LRA=11', description: 'Normalizes to -16 LUFS with a -1.5 dBTP ceiling' }
+    ],
+    richVocal: [
+        { filter: 'highpass=f=70', description: 'Removes rumble below 70Hz' },
+        { filter: 'lowpass=f=11000', description: 'Rolls off hiss above 11kHz' },
+        { filter: 'equalizer=f=120:width_type=o:width=1.5:g=3', description: 'Adds low-end weight around 120Hz' },
+        { filter: 'equalizer=f=250:width_type=o:width=1.5:g=2.5', description: 'Adds body around 250Hz' },
+        { filter: 'equalizer=f=400:width_type=o:width=1:g=1.5', description: 'Fills out the lower mids around 400Hz' },
+        { filter: 'equalizer=f=800:width_type=q:width=4:g=-0.5', description: 'Slightly cuts boxiness around 800Hz' },
+        { filter: 'equalizer=f=2500:width_type=o:width=1:g=1', description: 'Keeps words intelligible around 2.5kHz' },
+        { filter: 'equalizer=f=4000:width_type=q:width=20:g=-2', description: 'Notches a narrow band of harshness at 4kHz' },
+        { filter: 'compand=0.2|0.3:1|1:-90/-60|-60/-40|-40/-30|-20/-18:5:0:-90:0.3', description: 'Compresses loud peaks for a smoother level' },
+        { filter: 'loudnorm=I=-16:TP=-1.5:LRA=10', description: 'Normalizes to -16 LUFS with a -1.5 dBTP ceiling' }
+    ],
+    broadcastSound: [
+        { filter: 'highpass=f=140', description: 'Removes rumble below 140Hz' },
+        { filter: 'lowpass=f=11000', description: 'Rolls off hiss above 11kHz' },
+        { filter: 'equalizer=f=120:width_type=o:width=1.5:g=3', description: 'Adds low-end weight around 120Hz' },
+        { filter: 'equalizer=f=250:width_type=o:width=1.5:g=2.5', description: 'Adds body around 250Hz' },
+        { filter: 'equalizer=f=500:width_type=o:width=1:g=1', description: 'Fills out the mids around 500Hz' },
+        { filter: 'equalizer=f=800:width_type=o:width=1:g=-1', description: 'Cuts boxiness around 800Hz' },
+        { filter: 'equalizer=f=3000:width_type=o:width=1:g=2', description: 'Boosts presence around 3kHz' },
+        { filter: 'equalizer=f=4000:width_type=q:width=20:g=-2', description: 'Notches a narrow band of harshness at 4kHz' },
+        { filter: 'equalizer=f=6000:width_type=o:width=1:g=1', description: 'Adds crispness around 6kHz' },
+        { filter: 'compand=0.1|0.2:1|1:-90/-60|-60/-40|-40/-30|-30/-20|-20/-10:4:0:-90:0.2', description: 'Heavy radio-style compression' },
+        { filter: 'volume=1.0', description: 'Unity gain stage' },
+        { filter: 'alimiter=limit=0.9:attack=5:release=20', description: 'Catches peaks before normalization' },
+        { filter: 'loudnorm=I=-14:TP=-1:LRA=8', description: 'Normalizes to a broadcast-loud -14 LUFS' },
+    ],
+    femaleVocal: [
+        { filter: 'highpass=f=150', description: 'Removes rumble below 150Hz' },
+        { filter: 'lowpass=f=12000', description: 'Rolls off hiss above 12kHz' },
+        { filter: 'equalizer=f=200:width_type=o:width=1.5:g=1.5', description: 'Adds warmth around 200Hz' },
+        { filter: 'equalizer=f=400:width_type=o:width=1:g=1', description: 'Fills out the lower mids around 400Hz' },
+        { filter: 'equalizer=f=1200:width_type=o:width=1:g=-1', description: 'Cuts nasal tones around 1.2kHz' },
+        { filter: 'equalizer=f=2500:width_type=o:width=1:g=1.5', description: 'Lifts speech presence around 2.5kHz' },
+        { filter: 'equalizer=f=4000:width_type=q:width=20:g=-2', description: 'Notches a narrow band of harshness at 4kHz' },
+        { filter: 'equalizer=f=5000:width_type=o:width=1:g=1', description: 'Adds clarity around 5kHz' },
+        { filter: 'highshelf=f=8000:g=-2', description: 'Softens sibilance above 8kHz' },
+        { filter: 'compand=0.2|0.4:1|1:-90/-60|-60/-40|-40/-30|-20/-18:4:0:-90:0.4', description: 'Compresses loud peaks for a smoother level' },
+        { filter: 'loudnorm=I=-16:TP=-1.5:LRA=9', description: 'Normalizes to -16 LUFS with a -1.5 dBTP ceiling' },
+        { filter: 'adeclick=window=55:overlap=75:arorder=8:threshold=2:burst=2:method=add', description: 'Removes clicks and pops' }
+    ],
+    deepBass: [
+        { filter: 'highpass=f=50', description: 'Removes sub-bass rumble below 50Hz' },
+        { filter: 'lowpass=f=11000', description: 'Rolls off hiss above 11kHz' },
+        { filter: 'equalizer=f=80:width_type=o:width=1.2:g=4', description: 'Boosts low bass around 80Hz' },
+        { filter: 'equalizer=f=200:width_type=o:width=1:g=2', description: 'Adds warmth around 200Hz' },
+        { filter: 'equalizer=f=500:width_type=q:width=4:g=-2', description: 'Cuts boxiness around 500Hz' },
+        { filter: 'equalizer=f=4000:width_type=q:width=20:g=-2', description: 'Notches a narrow band of harshness at 4kHz' },
+        { filter: 'compand=0.3|0.5:1|1:-90/-70|-70/-50|-50/-30|-20/-15:5:0:-90:0.3', description: 'Slow compression that keeps the low end steady' },
+        { filter: 'loudnorm=I=-17:TP=-2:LRA=12', description: 'Normalizes to -17 LUFS leaving more dynamic range' }
+    ],
+    telephone: buildTelephoneStages(),
+    presenceBoost: [
+        { filter: 'highpass=f=150', description: 'Removes rumble below 150Hz' },
+        { filter: 'lowpass=f=11000', description: 'Rolls off hiss above 11kHz' },
+        { filter: 'equalizer=f=200:width_type=o:width=1:g=-1', description: 'Thins out muddiness around 200Hz' },
+        { filter: 'equalizer=f=2500:width_type=o:width=1.5:g=3', description: 'Strongly lifts presence around 2.5kHz' },
+        { filter: 'equalizer=f=4000:width_type=q:width=20:g=-3', description: 'Notches a narrow band of harshness at 4kHz' },
+        { filter: 'equalizer=f=6000:width_type=o:width=1:g=2', description: 'Adds crispness around 6kHz' },
+        { filter: 'compand=0.1|0.2:1|1:-90/-60|-60/-40|-40/-30|-20/-15:6:0:-90:0.1', description: 'Fast compression that keeps the voice forward' },
+        { filter: 'loudnorm=I=-15:TP=-1.5:LRA=7', description: 'Normalizes to -15 LUFS with a -1.5 dBTP ceiling' }
+    ],
+    deBoom: [
+        { filter: 'highpass=f=100', description: 'Removes rumble below 100Hz' },
+        { filter: 'lowpass=f=11000', description: 'Rolls off hiss above 11kHz' },
+        { filter: 'equalizer=f=150:width_type=q:width=5:g=-4', description: 'Cuts boom around 150Hz' },
+        { filter: 'equalizer=f=300:width_type=q:width=4:g=-2', description: 'Cuts muddiness around 300Hz' },
+        { filter: 'equalizer=f=2500:width_type=o:width=1:g=1', description: 'Keeps words intelligible around 2.5kHz' },
+        { filter: 'equalizer=f=4000:width_type=q:width=20:g=-2', description: 'Notches a narrow band of harshness at 4kHz' },
+        { filter: 'compand=0.2|0.3:1|1:-90/-60|-60/-40|-40/-30|-20/-18:6:0:-90:0.2', description: 'Compresses loud peaks for a smoother level' },
+        { filter: 'loudnorm=I=-16:TP=-1.5:LRA=10', description: 'Normalizes to -16 LUFS with a -1.5 dBTP ceiling' }
+    ],
+    gentleCurve: [
+        { filter: 'highpass=f=100', description: 'Removes rumble below 100Hz' },
+        { filter: 'lowpass=f=11000', description: 'Rolls off hiss above 11kHz' },
+        { filter: 'equalizer=f=100:width_type=o:width=1.5:g=1', description: 'Adds slight low-end weight around 100Hz' },
+        { filter: 'equalizer=f=500:width_type=q:width=3:g=-1', description: 'Slightly cuts boxiness around 500Hz' },
+        { filter: 'equalizer=f=3000:width_type=o:width=1.2:g=1', description: 'Slightly lifts presence around 3kHz' },
+        { filter: 'equalizer=f=4000:width_type=q:width=20:g=-1', description: 'Lightly notches harshness at 4kHz' },
+        { filter: 'compand=0.3|0.5:1|1:-90/-65|-65/-45|-45/-30|-20/-15:6:0:-90:0.3', description: 'Slow compression for a relaxed level' },
+        { filter: 'loudnorm=I=-16:TP=-1.5:LRA=11', description: 'Normalizes to -16 LUFS with a -1.5 dBTP ceiling' }
+    ],
+    thinToFull: [
+        { filter: 'highpass=f=85', description: 'Removes rumble below 85Hz' },
+        { filter: 'lowpass=f=11000', description: 'Rolls off hiss above 11kHz' },
+        { filter: 'equalizer=f=180:width_type=o:width=1.5:g=3', description: 'Adds body to thin voices around 180Hz' },
+        { filter: 'equalizer=f=350:width_type=o:width=1.2:g=2', description: 'Fills out the lower mids around 350Hz' },
+        { filter: 'equalizer=f=2800:width_type=o:width=1:g=1', description: 'Keeps words intelligible around 2.8kHz' },
+        { filter: 'equalizer=f=4000:width_type=q:width=20:g=-2.5', description: 'Notches a narrow band of harshness at 4kHz' },
+        { filter: 'compand=0.2|0.4:1|1:-90/-60|-60/-40|-40/-30|-20/-17:5:0:-90:0.2', description: 'Compresses loud peaks for a smoother level' },
+        { filter: 'loudnorm=I=-16.5:TP=-1.8:LRA=10', description: 'Normalizes to -16.5 LUFS with a -1.8 dBTP ceiling' }
+    ],
+    safety: [
+        { filter: 'loudnorm=I=-16:TP=-1:LRA=20', description: 'Gently normalizes to -16 LUFS without squeezing dynamics' },
+        { filter: SAFETY_LIMITER, description: 'Brickwall limiter at truePeakCeiling (default -1 dB) so the output never clips' }
+    ]
+};
+
+/**
+ * Normalizes a preset stage to its filter/description form
+ * @param {string|Object} stage - Raw filter string or { filter, description }
+ * @returns {Object} - Stage with filter and description (null when absent)
+ */
+function normalizeStage(stage) {
+    return typeof stage === 'string'
+        ? { filter: stage, description: null }
+        : { filter: stage.filter, description: stage.description || null };
+}
+
 /**
  * Gets audio filters for the specified preset
  * @param {string} preset - Name of the preset
  * @returns {Array} - Array of filter strings
  */
 function getPresetFilters(preset) {
-    const basePresets = {
-        clarity: [
-            'highpass=f=150',
-            'lowpass=f=11000',
-            'equalizer=f=250:width_type=o:width=1:g=0.5',
-            'equalizer=f=2500:width_type=o:width=1:g=1.5',
-            'equalizer=f=4000:width_type=q:width=20:g=-2',
-            'equalizer=f=6000:width_type=o:width=1:g=-1',
-            'compand=0.2|0.3:1|1:-90/-60|-60/-40|-40/-30|-20/-20:6:0:-90:0.2',
-            'loudnorm=I=-16:TP=-1.5:LRA=11'
-        ],
-        warmVocal: [
-            'highpass=f=100',
-            'lowpass=f=11000',
-            'equalizer=f=200:width_type=o:width=1:g=2',
-            'equalizer=f=600:width_type=o:width=1:g=1',
-            'equalizer=f=3000:width_type=o:width=1.5:g=1',
-            'equalizer=f=4000:width_type=q:width=20:g=-2',
-            'compand=0.2|0.3:1|1:-90/-60|-60/-40|-40/-30|-20/-15:5:0:-90:0.3',
-            'loudnorm=I=-16:TP=-1.5:LRA=10'
-        ],
-        brightVocal: [
-            'highpass=f=130',
-            'lowpass=f=12000',
-            'equalizer=f=3000:width_type=o:width=1:g=2',
-            'equalizer=f=4000:width_type=q:width=20:g=-2',
-            'equalizer=f=6000:width_type=o:width=1:g=0.5',
-            'equalizer=f=200:width_type=o:width=1:g=-1',
-            'compand=0.1|0.2:1|1:-90/-60|-60/-40|-40/-30|-20/-15:5:0:-90:0.1',
-            'loudnorm=I=-14:TP=-1.5:LRA=6'
-        ],
-        smoothVocal: [
-            'highpass=f=80',
-            'lowpass=f=11000',
-            'highshelf=f=6000:g=-6',
-            'equalizer=f=2000:width_type=o:width=1:g=1',
-            'equalizer=f=4000:width_type=q:width=20:g=-2',
-            'compand=0.3|0.5:1|1:-90/-60|-60/-40|-40/-30|-20/-15:4:0:-90:0.5',
-            'loudnorm=I=-16:TP=-1.5:LRA=11'
-        ],
-        richVocal: [
-            'highpass=f=70',
-            'lowpass=f=11000',
-            'equalizer=f=120:width_type=o:width=1.5:g=3',
-            'equalizer=f=250:width_type=o:width=1.5:g=2.5',
-            'equalizer=f=400:width_type=o:width=1:g=1.5',
-            'equalizer=f=800:width_type=q:width=4:g=-0.5',
-            'equalizer=f=2500:width_type=o:width=1:g=1',
-            'equalizer=f=4000:width_type=q:width=20:g=-2',
-            'compand=0.2|0.3:1|1:-90/-60|-60/-40|-40/-30|-20/-18:5:0:-90:0.3',
-            'loudnorm=I=-16:TP=-1.5:LRA=10'
-        ],
-        broadcastSound: [
-            'highpass=f=140',
-            'lowpass=f=11000',
-            'equalizer=f=120:width_type=o:width=1.5:g=3',
-            'equalizer=f=250:width_type=o:width=1.5:g=2.5',
-            'equalizer=f=500:width_type=o:width=1:g=1',
-            'equalizer=f=800:width_type=o:width=1:g=-1',
-            'equalizer=f=3000:width_type=o:width=1:g=2',
-            'equalizer=f=4000:width_type=q:width=20:g=-2',
-            'equalizer=f=6000:width_type=o:width=1:g=1',
-            'compand=0.1|0.2:1|1:-90/-60|-60/-40|-40/-30|-30/-20|-20/-10:4:0:-90:0.2',
-            'volume=1.0',
-            'alimiter=limit=0.9:attack=5:release=20',
-            'loudnorm=I=-14:TP=-1:LRA=8',
-        ],
-        femaleVocal: [
-            'highpass=f=150',
-            'lowpass=f=12000',
-            'equalizer=f=200:width_type=o:width=1.5:g=1.5',
-            'equalizer=f=400:width_type=o:width=1:g=1',
-            'equalizer=f=1200:width_type=o:width=1:g=-1',
-            'equalizer=f=2500:width_type=o:width=1:g=1.5',
-            'equalizer=f=4000:width_type=q:width=20:g=-2',
-            'equalizer=f=5000:width_type=o:width=1:g=1',
-            'highshelf=f=8000:g=-2',
-            'compand=0.2|0.4:1|1:-90/-60|-60/-40|-40/-30|-20/-18:4:0:-90:0.4',
-            'loudnorm=I=-16:TP=-1.5:LRA=9',
-            'adeclick=window=55:overlap=75:arorder=8:threshold=2:burst=2:method=add'
-        ],
-        deepBass: [
-            'highpass=f=50',
-            'lowpass=f=11000',
-            'equalizer=f=80:width_type=o:width=1.2:g=4',
-            'equalizer=f=200:width_type=o:width=1:g=2',
-            'equalizer=f=500:width_type=q:width=4:g=-2',
-            'equalizer=f=4000:width_type=q:width=20:g=-2',
-            'compand=0.3|0.5:1|1:-90/-70|-70/-50|-50/-30|-20/-15:5:0:-90:0.3',
-            'loudnorm=I=-17:TP=-2:LRA=12'
-        ],
-        telephone: [
-            'highpass=f=300',
-            'lowpass=f=3400',
-            'equalizer=f=1000:width_type=o:width=0.7:g=4',
-            'compand=0.1|0.1:1|1:-90/-40|-40/-20|-20/-10|-10/-5:3:0:-90:0.1',
-            'loudnorm=I=-14:TP=-3:LRA=5'
-        ],
-        presenceBoost: [
-            'highpass=f=150',
-            'lowpass=f=11000',
-            'equalizer=f=200:width_type=o:width=1:g=-1',
-            'equalizer=f=2500:width_type=o:width=1.5:g=3',
-            'equalizer=f=4000:width_type=q:width=20:g=-3',
-            'equalizer=f=6000:width_type=o:width=1:g=2',
-            'compand=0.1|0.2:1|1:-90/-60|-60/-40|-40/-30|-20/-15:6:0:-90:0.1',
-            'loudnorm=I=-15:TP=-1.5:LRA=7'
-        ],
-        deBoom: [
-            'highpass=f=100',
-            'lowpass=f=11000',
-            'equalizer=f=150:width_type=q:width=5:g=-4',
-            'equalizer=f=300:width_type=q:width=4:g=-2',
-            'equalizer=f=2500:width_type=o:width=1:g=1',
-            'equalizer=f=4000:width_type=q:width=20:g=-2',
-            'compand=0.2|0.3:1|1:-90/-60|-60/-40|-40/-30|-20/-18:6:0:-90:0.2',
-            'loudnorm=I=-16:TP=-1.5:LRA=10'
-        ],
-        gentleCurve: [
-            'highpass=f=100',
-            'lowpass=f=11000',
-            'equalizer=f=100:width_type=o:width=1.5:g=1',
-            'equalizer=f=500:width_type=q:width=3:g=-1',
-            'equalizer=f=3000:width_type=o:width=1.2:g=1',
-            'equalizer=f=4000:width_type=q:width=20:g=-1',
-            'compand=0.3|0.5:1|1:-90/-65|-65/-45|-45/-30|-20/-15:6:0:-90:0.3',
-            'loudnorm=I=-16:TP=-1.5:LRA=11'
-        ],
-        thinToFull: [
-            'highpass=f=85',
-            'lowpass=f=11000',
-            'equalizer=f=180:width_type=o:width=1.5:g=3',
-            'equalizer=f=350:width_type=o:width=1.2:g=2',
-            'equalizer=f=2800:width_type=o:width=1:g=1',
-            'equalizer=f=4000:width_type=q:width=20:g=-2.5',
-            'compand=0.2|0.4:1|1:-90/-60|-60/-40|-40/-30|-20/-17:5:0:-90:0.2',
-            'loudnorm=I=-16.5:TP=-1.8:LRA=10'
-        ]
-    };
-    
-    return basePresets[preset] || basePresets.clarity;
+    const stages = basePresets[preset] || basePresets.clarity;
+    return stages.map(stage => normalizeStage(stage).filter);
+}
+
+/**
+ * Concatenates the filters of several presets in order
+ * @param {Array<string>} presets - Ordered preset names; each must exist
+ * @returns {Array} - Array of filter strings
+ */
+function getChainedPresetFilters(presets) {
+    const unknown = presets.filter(name => !basePresets[name]);
+    if (unknown.length > 0) {
+        throw new Error(`Unknown preset(s): ${unknown.join(', ')}`);
+    }
+    return presets.flatMap(name => getPresetFilters(name));
+}
+
+/**
+ * Swaps the default telephone stages in a chain for a customized set
+ * @param {Array<string>} filters - Filter chain containing the telephone preset
+ * @param {Object} telephone - Options for buildTelephoneStages
+ * @returns {Array<string>} - Updated filter chain
+ */
+function customizeTelephone(filters, telephone) {
+    const defaults = getPresetFilters('telephone');
+    const start = filters.findIndex((_, index) =>
+        defaults.every((filter, offset) => filters[index + offset] === filter)
+    );
+    if (start === -1) {
+        throw new Error('telephone options require the telephone preset');
+    }
+    const custom = buildTelephoneStages(telephone).map(stage => stage.filter);
+    return [...filters.slice(0, start), ...custom, ...filters.slice(start + defaults.length)];
+}
+
+/**
+ * Gets whether each built-in preset is corrective or a creative effect
+ * @returns {Object} - Map of preset name to 'corrective' or 'creative'
+ */
+export function getPresetCategories() {
+    return Object.fromEntries(
+        Object.keys(basePresets).map(name => [name, CREATIVE_PRESETS.has(name) ? 'creative' : 'corrective'])
+    );
+}
+
+/**
+ * Lists every built-in preset with its annotated stages
+ * @returns {Object} - Map of preset name to an array of { filter, description }
+ */
+export function getPresetCatalog() {
+    const catalog = {};
+    for (const [name, stages] of Object.entries(basePresets)) {
+        catalog[name] = stages.map(normalizeStage);
+    }
+    return catalog;
+}
+
+/** Fallback descriptions for stages that aren't taken verbatim from a preset */
+const FILTER_DESCRIPTIONS = {
+    acrusher: 'Reduces bit depth for a gritty, lo-fi sound',
+    adeclip: 'Rebuilds waveform peaks that were flattened by clipping',
+    aecho: 'Adds room ambience from a series of fading reflections',
+    aeval: 'Mixes generated noise into each channel',
+    afir: 'Convolves the audio with an impulse response for mic or room matching',
+    aformat: 'Converts the channel layout or sample format before processing',
+    agate: 'Noise gate that quiets the signal between phrases',
+    alimiter: 'Brickwall limiter that holds peaks under a ceiling',
+    apad: 'Pads the end with silence up to a minimum duration',
+    compand: 'Compresses or expands dynamics along a level curve',
+    dynaudnorm: 'Evens out loudness frame by frame toward a peak target',
+    equalizer: 'Boosts or cuts a frequency band',
+    highpass: 'Removes content below a cutoff frequency',
+    loudnorm: 'Normalizes integrated loudness (EBU R128)',
+    lowpass: 'Removes content above a cutoff frequency',
+    pan: 'Applies a separate gain to each channel',
+    stereotools: 'Adjusts the balance between the left and right channels',
+    volume: 'Applies a fixed gain change'
+};
+
+/**
+ * Breaks a filter chain down into each filter's name, parameters and purpose
+ * @param {Array<string>} filters - Filter chain that ran
+ * @returns {Array<Object>} - [{ filter, name, params, description }]
+ */
+function explainFilterChain(filters) {
+    const presetDescriptions = new Map(
+        Object.values(basePresets).flat().map(normalizeStage).map(stage => [stage.filter, stage.description])
+    );
+
+    return filters.map(filter => {
+        const separator = filter.indexOf('=');
+        const name = separator === -1 ? filter : filter.slice(0, separator);
+        const params = {};
+        if (separator !== -1) {
+            // Unnamed options are keyed by their position, as ffmpeg reads them
+            filter.slice(separator + 1).split(':').forEach((option, index) => {
+                const equals = option.indexOf('=');
+                if (equals === -1) {
+                    params[index] = option;
+                } else {
+                    params[option.slice(0, equals)] = option.slice(equals + 1);
+                }
+            });
+        }
+        const description = presetDescriptions.get(filter) || FILTER_DESCRIPTIONS[name] || null;
+        return { filter, name, params, description };
+    });
+}
+
+/**
+ * Builds an agate noise gate filter
+ * @param {Object} gate - threshold (linear 0-1), ratio, attack and release (ms)
+ * @returns {string} - agate filter string
+ */
+function buildGateFilter(gate) {
+    const {
+        threshold = 0.02,
+        ratio = 4,
+        attack = 10,
+        release = 250
+    } = gate;
+
+    if (!(threshold > 0 && threshold <= 1)) {
+        throw new Error(`Gate threshold must be between 0 and 1: ${threshold}`);
+    }
+    if (!(ratio >= 1 && ratio <= 9000)) {
+        throw new Error(`Gate ratio must be between 1 and 9000: ${ratio}`);
+    }
+    if (!(attack >= 0.01 && attack <= 9000) || !(release >= 0.01 && release <= 9000)) {
+        throw new Error('Gate attack and release must be between 0.01 and 9000 ms');
+    }
+
+    return `agate=threshold=${threshold}:ratio=${ratio}:attack=${attack}:release=${release}`;
+}
+
+/**
+ * Builds a compand stage that expands dynamics, the inverse of the preset
+ * compand stages: downward mode pushes quiet passages further down, upward
+ * mode lifts peaks back up toward 0 dB
+ * @param {Object} expander - mode (downward or upward), threshold (dB), ratio, attack and release (ms)
+ * @returns {string} - compand filter string
+ */
+function buildExpanderFilter(expander) {
+    const {
+        mode = 'downward',
+        threshold = -40,
+        ratio = 2,
+        attack = 10,
+        release = 100
+    } = expander;
+
+    if (mode !== 'downward' && mode !== 'upward') {
+        throw new Error(`Expander mode must be downward or upward: ${mode}`);
+    }
+    if (!(threshold >= -80 && threshold <= -1)) {
+        throw new Error(`Expander threshold must be between -80 and -1 dB: ${threshold}`);
+    }
+    if (!(ratio > 1 && ratio <= 10)) {
+        throw new Error(`Expander ratio must be above 1 and at most 10: ${ratio}`);
+    }
+    if (!(attack >= 1 && attack <= 1000) || !(release >= 1 && release <= 5000)) {
+        throw new Error('Expander attack must be 1-1000 ms and release 1-5000 ms');
+    }
+
+    // Unity below/above the threshold, then a slope of `ratio` until the curve hits the floor or 0 dB
+    const points = mode === 'downward'
+        ? ['-90/-90', `${(threshold - (threshold + 90) / ratio).toFixed(2)}/-90`, `${threshold}/${threshold}`, '0/0']
+        : ['-90/-90', `${threshold}/${threshold}`, `${(threshold - threshold / ratio).toFixed(2)}/0`, '0/0'];
+    return `compand=attacks=${attack / 1000}:decays=${release / 1000}:points=${points.join('|')}`;
+}
+
+/** Echo delays in ms for each simulated room; larger rooms reflect later and more often */
+const REVERB_ROOMS = {
+    small: [17, 29, 41],
+    medium: [31, 53, 79, 107],
+    hall: [61, 113, 173, 241, 317]
+};
+
+/**
+ * Builds an aecho stage that simulates room ambience
+ * @param {Object} reverb - room (small, medium or hall), wet (0-1) and decay (0-1)
+ * @returns {string} - aecho filter string
+ */
+function buildReverbFilter(reverb) {
+    const {
+        room = 'small',
+        wet = 0.3,
+        decay = 0.5
+    } = reverb;
+
+    const delays = REVERB_ROOMS[room];
+    if (!delays) {
+        throw new Error(`Unknown reverb room: ${room}`);
+    }
+    if (!(wet > 0 && wet <= 1)) {
+        throw new Error(`Reverb wet level must be between 0 and 1: ${wet}`);
+    }
+    if (!(decay > 0 && decay < 1)) {
+        throw new Error(`Reverb decay must be between 0 and 1: ${decay}`);
+    }
+
+    // Each reflection is quieter than the last; the dry signal drops as the mix gets wetter
+    const decays = delays.map((_, index) => (wet * decay ** index).toFixed(3));
+    const dry = (1 - wet / 2).toFixed(3);
+    return `aecho=${dry}:1:${delays.join('|')}:${decays.join('|')}`;
+}
+
+/**
+ * Builds a brickwall alimiter stage that holds sample peaks under a ceiling;
+ * buildFilterChain oversamples ahead of it to catch inter-sample peaks
+ * @param {number} ceiling - Peak ceiling in dB (-24 to 0)
+ * @returns {string} - alimiter filter string
+ */
+function buildLimiterFilter(ceiling) {
+    if (!(ceiling >= -24 && ceiling <= 0)) {
+        throw new Error(`Limiter ceiling must be between -24 and 0 dB: ${ceiling}`);
+    }
+
+    // alimiter takes a linear limit; disable auto-level so it only attenuates
+    const limit = Math.pow(10, ceiling / 20).toFixed(4);
+    return `alimiter=limit=${limit}:attack=1:release=50:level=disabled`;
+}
+
+/**
+ * Resolves a path that must stay inside a directory. Absolute paths, ..
+ * segments and symlinks pointing out of the directory are all rejected.
+ * @param {string} directory - Allowed directory, relative to the working directory
+ * @param {string} target - Path relative to the directory
+ * @returns {string|null} - Absolute path (symlinks followed), or null when it escapes
+ */
+export function resolveContainedPath(directory, target) {
+    const root = path.resolve(process.cwd(), directory);
+    const realRoot = fs.existsSync(root) ? fs.realpathSync(root) : root;
+    const candidate = path.resolve(realRoot, String(target));
+    if (!candidate.startsWith(realRoot + path.sep)) {
+        return null;
+    }
+    // Follow symlinks so a link inside the directory can't point outside it
+    const realPath = fs.existsSync(candidate) ? fs.realpathSync(candidate) : candidate;
+    return realPath.startsWith(realRoot + path.sep) ? realPath : null;
+}
+
+/**
+ * Loads an EQ profile from a JSON file and converts its bands to equalizer filters
+ * @param {string} eqFile - Profile path, relative to eqDir
+ * @param {string} eqDir - Directory profiles must live in
+ * @returns {Array<string>} - Array of equalizer filter strings
+ */
+function loadEqProfile(eqFile, eqDir) {
+    // Prevent path traversal out of the profile directory
+    const profilePath = resolveContainedPath(eqDir, eqFile);
+    if (!profilePath) {
+        throw new Error(`EQ profile must be inside ${eqDir}: ${eqFile}`);
+    }
+
+    const profile = fs.readJSONSync(profilePath);
+    const bands = Array.isArray(profile) ? profile : profile.bands;
+    if (!Array.isArray(bands)) {
+        throw new Error(`EQ profile ${eqFile} does not contain a list of bands`);
+    }
+
+    return bands.map((band, index) => {
+        const { freq, width, gain, width_type = 'o' } = band;
+        if (![freq, width, gain].every(Number.isFinite) || !['h', 'q', 'o', 's', 'k'].includes(width_type)) {
+            throw new Error(`EQ profile ${eqFile} has an invalid band at index ${index}`);
+        }
+        return `equalizer=f=${freq}:width_type=${width_type}:width=${width}:g=${gain}`;
+    });
+}
+
+/**
+ * Inserts filters ahead of the first dynamics/loudness stage so that
+ * tonal changes are applied before leveling
+ * @param {Array<string>} filters - Existing filter chain
+ * @param {Array<string>} inserted - Filters to insert
+ * @returns {Array<string>} - New filter chain
+ */
+function insertBeforeDynamics(filters, inserted) {
+    const index = filters.findIndex(filter => /^(compand|alimiter|loudnorm)=/.test(filter));
+    if (index === -1) {
+        return [...filters, ...inserted];
+    }
+    return [...filters.slice(0, index), ...inserted, ...filters.slice(index)];
+}
+
+/**
+ * Extracts the parameters of the last loudnorm stage in a chain
+ * @param {Array<string>} filters - Filter chain
+ * @returns {Object|null} - Parameter map (e.g. { I, TP, LRA }), or null without loudnorm
+ */
+function getLoudnormParams(filters) {
+    const loudnorm = filters.filter(filter => filter.startsWith('loudnorm=')).pop();
+    if (!loudnorm) {
+        return null;
+    }
+    return Object.fromEntries(
+        loudnorm.slice('loudnorm='.length).split(':').map(param => param.split('='))
+    );
+}
+
+/**
+ * Rewrites any loudnorm stage to run single-pass using loudness values
+ * measured elsewhere, skipping loudnorm's own analysis
+ * @param {Array<string>} filters - Filter chain to rewrite
+ * @param {Object} measured - measured_I, measured_TP, measured_LRA, measured_thresh and offset
+ * @returns {Array<string>} - Filter chain with the loudnorm stage updated
+ */
+function applyMeasuredLoudness(filters, measured) {
+    const keys = ['measured_I', 'measured_TP', 'measured_LRA', 'measured_thresh', 'offset'];
+    const params = keys.map(key => {
+        const value = Number(measured[key]);
+        if (measured[key] === undefined || !Number.isFinite(value)) {
+            throw new Error(`Invalid measured loudness value for ${key}: ${measured[key]}`);
+        }
+        return `${key}=${value}`;
+    });
+
+    return filters.map(filter =>
+        filter.startsWith('loudnorm=')
+            ? `${filter}:${params.join(':')}:linear=true`
+            : filter
+    );
 }
 
 /**
@@ -255,6 +2553,21 @@ export async function cleanupOldFiles(directory, days = 5) {
     }
 }
 
+/** Cap on the output directory's total size, set from audio.maxDirectoryBytes; null for none */
+let maxDirectoryBytes = null;
+
+/**
+ * Sets the output directory's disk budget. Oldest files are evicted to make
+ * room for each new output.
+ * @param {number|null} bytes - Maximum total size in bytes, or null to disable
+ */
+export function setMaxDirectoryBytes(bytes) {
+    if (bytes !== null && !(Number.isInteger(bytes) && bytes > 0)) {
+        throw new Error(`Directory budget must be a positive integer number of bytes: ${bytes}`);
+    }
+    maxDirectoryBytes = bytes;
+}
+
 /**
  * Schedule automatic cleanup of a directory at regular intervals
  * @param {string} directory - Directory to clean up
@@ -266,25 +2579,83 @@ export function scheduleCleanup(directory, days = 5, intervalHours = 24) {
     fs.mkdir(directory, { recursive: true })
         .catch(err => logger.error("Audio", `Error creating directory ${directory}: ${err.message}`));
 
-    // Run cleanup immediately
-    cleanupOldFiles(directory, days)
+    const runCleanup = (errorLabel) => cleanupOldFiles(directory, days)
         .then(count => {
             if (count > 0) {
                 logger.log("Audio", `Cleaned up ${count} old files from ${directory}`);
             }
+            // Also apply the disk budget, when one is configured
+            if (maxDirectoryBytes) {
+                const evicted = enforceDiskLimit(directory, maxDirectoryBytes);
+                if (evicted > 0) {
+                    logger.log("Audio", `Evicted ${evicted} oldest files to keep ${directory} under ${maxDirectoryBytes} bytes`);
+                }
+            }
         })
-        .catch(err => logger.error("Audio", `Error in cleanup: ${err.message}`));
+        .catch(err => logger.error("Audio", `Error in ${errorLabel}: ${err.message}`));
+
+    // Run cleanup immediately
+    runCleanup('cleanup');
 
     const intervalMs = intervalHours * 60 * 60 * 1000;
-    const timer = setInterval(() => {
-        cleanupOldFiles(directory, days)
-            .then(count => {
-                if (count > 0) {
-                    logger.log("Audio", `Cleaned up ${count} old files from ${directory}`);
-                }
-            })
-            .catch(err => logger.error("Audio", `Error in scheduled cleanup: ${err.message}`));
-    }, intervalMs);
+    const timer = setInterval(() => runCleanup('scheduled cleanup'), intervalMs);
 
     return timer;
-}
\ No newline at end of file
+}
+
+/**
+ * Deletes the oldest files in a directory until its total size fits a budget
+ * @param {string} directory - Directory to trim
+ * @param {number} maxBytes - Maximum total size of the directory's files
+ * @param {Iterable<string>} keepFilePaths - Files that must never be evicted (e.g. a job's fresh outputs)
+ * @return {number} - Number of files deleted
+ */
+export function enforceDiskLimit(directory, maxBytes, keepFilePaths = []) {
+    const keep = new Set([...keepFilePaths].map(filePath => path.resolve(filePath)));
+    // Concurrent jobs and cleanups delete files too, so one vanishing mid-scan is expected
+    const files = fs.readdirSync(directory)
+        .map(file => path.resolve(directory, file))
+        .map(filePath => ({ filePath, stats: statIfExists(filePath) }))
+        .filter(({ stats }) => stats && stats.isFile())
+        .sort((a, b) => a.stats.mtimeMs - b.stats.mtimeMs);
+
+    let totalBytes = files.reduce((total, { stats }) => total + stats.size, 0);
+    let deletedCount = 0;
+
+    for (const { filePath, stats } of files) {
+        if (totalBytes <= maxBytes) {
+            break;
+        }
+        if (keep.has(filePath)) {
+            continue;
+        }
+        try {
+            fs.unlinkSync(filePath);
+            deletedCount++;
+        } catch (error) {
+            if (error.code !== 'ENOENT') {
+                throw error;
+            }
+        }
+        // Gone either way, so it no longer counts toward the budget
+        totalBytes -= stats.size;
+    }
+
+    return deletedCount;
+}
+
+/**
+ * Stats a file that may be deleted at any moment
+ * @param {string} filePath - File to stat
+ * @return {fs.Stats|null} - Its stats, or null when it no longer exists
+ */
+function statIfExists(filePath) {
+    try {
+        return fs.statSync(filePath);
+    } catch (error) {
+        if (error.code === 'ENOENT') {
+            return null;
+        }
+        throw error;
+    }
+}
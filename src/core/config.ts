@@ -334,6 +334,121 @@ export function getConfigValueType(configPath: string): ConfigValueType | undefi
   return originalConfigTypes[configPath];
 }
 
+/** Key names whose values are secrets and must never be echoed back */
+const SECRET_KEY_PATTERN = /secret|password|token$|apikey$|^key$/i;
+
+/** user:password@ credentials embedded in a URL */
+const URL_USERINFO_PATTERN = /(\/\/)[^/?#@\s]+@/g;
+
+/** Query parameters in a URL that carry credentials, e.g. ?api_key=... */
+const URL_SECRET_PARAM_PATTERN = /([?&][^=&#\s]*(?:key|token|secret|password|auth|sig)[^=&#\s]*=)[^&#\s]+/gi;
+
+/**
+ * Values in effect that differ from the file, such as settings rejected at
+ * startup or toggled at runtime, keyed by dot-notation path
+ */
+const effectiveOverrides = new Map<string, unknown>();
+
+/**
+ * Records the value a setting actually has in this process, so the redacted
+ * configuration reports it instead of what the file says
+ *
+ * @param configPath - Dot-notation path of the setting
+ * @param value - Value in effect
+ */
+export function setEffectiveConfigValue(configPath: string, value: unknown): void {
+  effectiveOverrides.set(configPath, value);
+}
+
+/**
+ * Pins settings that are only read at startup to the values they were read
+ * with, so later edits to the file aren't reported as if they were in effect
+ *
+ * @param configPaths - Dot-notation paths of startup-only settings
+ */
+export async function pinStartupConfigValues(configPaths: string[]): Promise<void> {
+  for (const configPath of configPaths) {
+    if (!effectiveOverrides.has(configPath)) {
+      effectiveOverrides.set(configPath, structuredClone(await retrieveConfigValue(configPath)));
+    }
+  }
+}
+
+/**
+ * Masks credentials embedded in a URL-like string
+ *
+ * @param value - String that may contain URLs
+ * @returns The string with userinfo and credential query parameters redacted
+ */
+function redactUrlCredentials(value: string): string {
+  return value
+    .replace(URL_USERINFO_PATTERN, '$1[redacted]@')
+    .replace(URL_SECRET_PARAM_PATTERN, '$1[redacted]');
+}
+
+/**
+ * Redacts a single configuration value
+ *
+ * @param key - Key the value is stored under
+ * @param value - The value to redact
+ * @returns The value with secrets masked
+ */
+function redactValue(key: string, value: unknown): unknown {
+  if (Array.isArray(value)) {
+    return value.map((item) => redactValue(key, item));
+  }
+  if (typeof value === 'object' && value !== null) {
+    return redactSecrets(value as ConfigObject);
+  }
+  if (typeof value !== 'string' || value.length === 0) {
+    return value;
+  }
+  return SECRET_KEY_PATTERN.test(key) ? '[redacted]' : redactUrlCredentials(value);
+}
+
+/**
+ * Recursively copies a configuration object, masking secret values
+ *
+ * @param obj - The configuration object to copy
+ * @returns A copy with non-empty secret strings replaced by "[redacted]"
+ *   and credentials stripped from URLs
+ */
+function redactSecrets(obj: ConfigObject): ConfigObject {
+  const redacted: ConfigObject = {};
+
+  for (const key in obj) {
+    redacted[key] = redactValue(key, obj[key]);
+  }
+
+  return redacted;
+}
+
+/**
+ * Gets the configuration in effect for this process with secrets masked,
+ * for inspecting a running instance. Settings applied only at startup or
+ * changed at runtime report their effective values rather than the file's.
+ *
+ * @returns The effective configuration with secret values redacted
+ */
+export async function getRedactedConfig(): Promise<ConfigObject> {
+  await reloadConfig();
+  const effective = structuredClone(configCache!);
+
+  for (const [configPath, value] of effectiveOverrides) {
+    const pathParts = configPath.split('.');
+    let obj: Record<string, unknown> = effective;
+    for (const part of pathParts.slice(0, -1)) {
+      if (!obj[part] || typeof obj[part] !== 'object') {
+        obj[part] = {};
+      }
+      obj = obj[part] as Record<string, unknown>;
+    }
+    obj[pathParts[pathParts.length - 1]!] = value;
+  }
+
+  return redactSecrets(effective);
+}
+
 export default {
   loadConfig,
   retrieveConfigValue,
@@ -344,4 +459,7 @@ export default {
   getAllConfigPaths,
   hasConfigValue,
   getConfigValueType,
+  getRedactedConfig,
+  setEffectiveConfigValue,
+  pinStartupConfigValues,
 };
@@ -151,7 +151,7 @@ export async function respondWithVoice(
 
     if (userObj.ttsUpsamplePref) {
       try {
-        const processedFilePath = processAudio(audioFilePath, {
+        const processedFilePath = await processAudio(audioFilePath, {
           preset: userObj.ttsEqPref || 'clarity',
           userId: userObj.user_id,
         });
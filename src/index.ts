@@ -5,7 +5,7 @@
 
 import Fastify, { FastifyInstance, FastifyRequest, FastifyReply } from 'fastify';
 import fs from 'fs-extra';
-import { join } from 'path';
+import { dirname, join } from 'path';
 import * as crypto from 'crypto';
 import type { WebSocket } from 'ws';
 
@@ -21,8 +21,30 @@ import * as vectorDb from './core/vector-db.js';
 import * as responseGenerator from './core/response-generator.js';
 import * as embeddings from './core/embeddings.js';
 import { initAllAPIs, returnAPIKeys, checkForAuth, returnAuthObject } from './core/api-helper.js';
-import { retrieveConfigValue, loadConfig } from './core/config.js';
+import { retrieveConfigValue, loadConfig, setEffectiveConfigValue, pinStartupConfigValues } from './core/config.js';
 import { setupTemplating } from '../template-engine.js';
+import {
+  createDirectoryChainResolver,
+  registerOutputFormat,
+  registerProcessingProfile,
+  setAllowedOutputFormats,
+  setAuditLogPath,
+  setChainResolver,
+  setCircuitBreaker,
+  setFfmpegDebugLogging,
+  setFfmpegNiceness,
+  setFilterChainLimits,
+  setInputRules,
+  setMaxDirectoryBytes,
+  setPostProcessHook,
+  warmUpFfmpeg,
+} from '../audio-processor.js';
+import type {
+  InputRules,
+  OutputFormatDefinition,
+  PostProcessHook,
+  ProcessAudioOptions,
+} from '../audio-processor.js';
 
 // Logger
 import { logger } from './core/logger.js';
@@ -30,6 +52,7 @@ import { logger } from './core/logger.js';
 // Type imports
 import type {
   ServerOptions,
+  TlsVersion,
   ConnectionLimits,
   RateLimitData,
   ModelInfo,
@@ -49,6 +72,7 @@ import type {
   ExpressionMapping,
   EventListenerEntry,
 } from './types/server.types.js';
+import type { AuthenticatedRequest } from './types/routes.types.js';
 
 // ==================== ERROR HANDLERS ====================
 
@@ -166,9 +190,31 @@ class ConnectionState {
 
 // ==================== SERVER CREATION ====================
 
+/** TLS versions accepted by server.tls.minVersion */
+const TLS_VERSIONS: TlsVersion[] = ['TLSv1.2', 'TLSv1.3'];
+
+/**
+ * Paths that keep accepting writes in maintenance mode: Twitch revokes EventSub
+ * subscriptions whose callbacks fail, and signing in or changing account
+ * settings doesn't start any processing
+ */
+const MAINTENANCE_EXEMPT_PREFIXES = ['/api/v1/twitch/', '/api/v1/auth/', '/web/'];
+
 const createServer = async (): Promise<FastifyInstance<any, any, any, any, any>> => {
-  const certPath = join(process.cwd(), 'self_signed.crt');
-  const keyPath = join(process.cwd(), 'self_signed.key');
+  // Configured certificates take precedence over the self-signed pair
+  const configuredCertPath = await retrieveConfigValue<string>('server.tls.certPath');
+  const configuredKeyPath = await retrieveConfigValue<string>('server.tls.keyPath');
+  const minTlsVersion = await retrieveConfigValue<string>('server.tls.minVersion');
+  const useConfiguredTls = Boolean(configuredCertPath && configuredKeyPath);
+  const certPath = useConfiguredTls ? configuredCertPath! : join(process.cwd(), 'self_signed.crt');
+  const keyPath = useConfiguredTls ? configuredKeyPath! : join(process.cwd(), 'self_signed.key');
+
+  if (useConfiguredTls && !((await fs.pathExists(certPath)) && (await fs.pathExists(keyPath)))) {
+    throw new Error(`TLS certificate or key not found: ${certPath}, ${keyPath}`);
+  }
+  if (minTlsVersion && !TLS_VERSIONS.includes(minTlsVersion as TlsVersion)) {
+    throw new Error(`Unsupported server.tls.minVersion: ${minTlsVersion}`);
+  }
 
   const serverOptions: ServerOptions = {
     trustProxy: true,
@@ -186,6 +232,7 @@ const createServer = async (): Promise<FastifyInstance<any, any, any, any, any>>
       allowHTTP1: true,
       key: await fs.readFile(keyPath),
       cert: await fs.readFile(certPath),
+      ...(minTlsVersion && { minVersion: minTlsVersion as TlsVersion }),
     };
     serverOptions.http2 = true;
     serverOptions.http = {
@@ -193,7 +240,12 @@ const createServer = async (): Promise<FastifyInstance<any, any, any, any, any>>
       keepAliveTimeout: 120000,
       headersTimeout: 65000,
     };
-    logger.log('Server', 'HTTPS server configured with self-signed certificates');
+    logger.log(
+      'Server',
+      useConfiguredTls
+        ? `HTTPS server configured with certificate ${certPath}`
+        : 'HTTPS server configured with self-signed certificates'
+    );
   } else {
     logger.warn('Server', 'SSL certificates not found, running HTTP server for development');
   }
@@ -220,6 +272,77 @@ const createServer = async (): Promise<FastifyInstance<any, any, any, any, any>>
     attachFieldsToBody: true,
   });
 
+  // Structured access log, kept apart from the application log for analytics
+  const accessLogPath = await retrieveConfigValue<string>('server.accessLog.path');
+  if (accessLogPath) {
+    await fs.ensureDir(dirname(accessLogPath));
+    const accessLog = fs.createWriteStream(accessLogPath, { flags: 'a' });
+    accessLog.on('error', (error: Error) => {
+      logger.error('Server', `Access log write failed: ${error.message}`);
+    });
+
+    fastify.addHook('onResponse', async (request, reply) => {
+      const contentLength = Number(reply.getHeader('content-length'));
+      accessLog.write(
+        JSON.stringify({
+          time: new Date().toISOString(),
+          request_id: request.id,
+          method: request.method,
+          path: request.url,
+          status: reply.statusCode,
+          duration_ms: Math.round(reply.elapsedTime),
+          user: (request as Partial<AuthenticatedRequest>).user?.user_id ?? null,
+          bytes: Number.isFinite(contentLength) ? contentLength : null,
+          ip: request.ip,
+        }) + '\n'
+      );
+    });
+    logger.log('Server', `Writing access log to ${accessLogPath}`);
+  }
+
+  // Read-only maintenance mode: reads and downloads keep working while anything
+  // that would start new work gets a 503, and WebSocket replies skip audio
+  // processing. server.maintenanceMode is read on each request; SIGUSR2 flips
+  // it and overrides the config until restart.
+  let maintenanceOverride: boolean | null = null;
+  const isMaintenanceMode = async (): Promise<boolean> =>
+    maintenanceOverride ?? Boolean(await retrieveConfigValue<boolean>('server.maintenanceMode'));
+  process.on('SIGUSR2', () => {
+    void isMaintenanceMode().then((enabled) => {
+      maintenanceOverride = !enabled;
+      setEffectiveConfigValue('server.maintenanceMode', maintenanceOverride);
+      logger.log('Server', `Maintenance mode ${maintenanceOverride ? 'enabled' : 'disabled'}`);
+    });
+  });
+
+  fastify.addHook('onRequest', async (request, reply) => {
+    if (['GET', 'HEAD', 'OPTIONS'].includes(request.method)) {
+      return;
+    }
+    if (MAINTENANCE_EXEMPT_PREFIXES.some((prefix) => request.url.startsWith(prefix))) {
+      return;
+    }
+    if (await isMaintenanceMode()) {
+      reply.header('Retry-After', '300');
+      return reply.code(503).send({ error: 'Service is in maintenance mode; try again later' });
+    }
+  });
+
+  // Indent JSON responses on request (?pretty=true) for reading them in a terminal
+  fastify.addHook('onSend', async (request, reply, payload) => {
+    const { pretty } = (request.query || {}) as { pretty?: string };
+    const contentType = String(reply.getHeader('content-type') || '');
+    if (pretty !== 'true' || typeof payload !== 'string' || !contentType.includes('application/json')) {
+      return payload;
+    }
+
+    try {
+      return JSON.stringify(JSON.parse(payload), null, 2);
+    } catch {
+      return payload;
+    }
+  });
+
   // Error handler - use generic handler to avoid HTTP2 type conflicts
   fastify.setErrorHandler((error, request, reply) => {
     const err = error as Error & { code?: string };
@@ -889,6 +1012,11 @@ const createServer = async (): Promise<FastifyInstance<any, any, any, any, any>>
     const { user, modelInfo } = connectionState;
 
     try {
+      // WebSocket replies are where audio processing starts, so maintenance mode stops it here
+      if (await isMaintenanceMode()) {
+        throw new Error('Service is in maintenance mode; try again later');
+      }
+
       await sendMessageToConnection(connectionState, {
         type: 'synthesis-started',
         response_id: responseId,
@@ -1071,10 +1199,22 @@ const createServer = async (): Promise<FastifyInstance<any, any, any, any, any>>
     outputDir: 'final',
     prefix: '/files/audio',
     addContentDisposition: true,
+    minFreeBytes: await retrieveConfigValue<number>('audio.minFreeDiskBytes'),
+    maxConcurrentDownloads: await retrieveConfigValue<number>('audio.maxConcurrentDownloads'),
   });
   await fastify.register(twitchEventSubRoutes, { prefix: '/api/v1/twitch' });
   await fastify.register(webRoutes, { prefix: '/web' });
 
+  // The listener, access log and audio route limits were fixed above; report them as such
+  await pinStartupConfigValues([
+    'server.tls.certPath',
+    'server.tls.keyPath',
+    'server.tls.minVersion',
+    'server.accessLog.path',
+    'audio.minFreeDiskBytes',
+    'audio.maxConcurrentDownloads',
+  ]);
+
   return fastify;
 };
 
@@ -1196,6 +1336,140 @@ export async function initializeApp(): Promise<InitializeResult> {
     ];
 
     await loadConfig();
+
+    // Register any extra audio output formats defined in config
+    const outputFormats = await retrieveConfigValue<Record<string, OutputFormatDefinition>>('audio.outputFormats');
+    const registeredFormats: Record<string, OutputFormatDefinition> = {};
+    for (const [name, definition] of Object.entries(outputFormats || {})) {
+      try {
+        registerOutputFormat(name, definition);
+        registeredFormats[name] = definition;
+      } catch (error) {
+        logger.error('Audio', `Skipping output format ${name}: ${(error as Error).message}`);
+      }
+    }
+    setEffectiveConfigValue('audio.outputFormats', registeredFormats);
+
+    // Limit deployments to a subset of output formats, e.g. lossless only
+    const allowedOutputFormats = await retrieveConfigValue<string[]>('audio.allowedOutputFormats');
+    if (allowedOutputFormats && allowedOutputFormats.length > 0) {
+      try {
+        setAllowedOutputFormats(allowedOutputFormats);
+      } catch (error) {
+        logger.error('Audio', `Ignoring audio.allowedOutputFormats: ${(error as Error).message}`);
+        setEffectiveConfigValue('audio.allowedOutputFormats', []);
+      }
+    }
+
+    // Register named audio processing profiles defined in config
+    const profiles = await retrieveConfigValue<Record<string, ProcessAudioOptions>>('audio.profiles');
+    const registeredProfiles: Record<string, ProcessAudioOptions> = {};
+    for (const [name, profileOptions] of Object.entries(profiles || {})) {
+      try {
+        registerProcessingProfile(name, profileOptions);
+        registeredProfiles[name] = profileOptions;
+      } catch (error) {
+        logger.error('Audio', `Skipping processing profile ${name}: ${(error as Error).message}`);
+      }
+    }
+    setEffectiveConfigValue('audio.profiles', registeredProfiles);
+
+    // Stored filter chains referenced by chainId
+    const chainDir = await retrieveConfigValue<string>('audio.chainDir');
+    if (chainDir) {
+      setChainResolver(createDirectoryChainResolver(chainDir));
+    }
+
+    // Chain size caps apply to every request; they are not a per-request option
+    const filterChainLimits = await retrieveConfigValue<{ maxFilters?: number; maxFilterLength?: number }>('audio.filterChainLimits');
+    if (filterChainLimits) {
+      try {
+        setFilterChainLimits(filterChainLimits);
+      } catch (error) {
+        logger.error('Audio', `Ignoring audio.filterChainLimits: ${(error as Error).message}`);
+        setEffectiveConfigValue('audio.filterChainLimits', { maxFilters: 64, maxFilterLength: 8192 });
+      }
+    }
+
+    // Disk budget for processed audio, shared by every user's outputs
+    const maxDirectoryBytes = await retrieveConfigValue<number>('audio.maxDirectoryBytes');
+    if (maxDirectoryBytes) {
+      try {
+        setMaxDirectoryBytes(maxDirectoryBytes);
+      } catch (error) {
+        logger.error('Audio', `Ignoring audio.maxDirectoryBytes: ${(error as Error).message}`);
+        setEffectiveConfigValue('audio.maxDirectoryBytes', 0);
+      }
+    }
+
+    // Verbose ffmpeg output in the logs, for diagnosing filter chains
+    setFfmpegDebugLogging(Boolean(await retrieveConfigValue<boolean>('audio.debugLogging')));
+
+    // Run ffmpeg at a lower priority on hosts shared with latency-sensitive services
+    const ffmpegNiceness = await retrieveConfigValue<number>('audio.ffmpegNiceness');
+    if (ffmpegNiceness) {
+      try {
+        setFfmpegNiceness(ffmpegNiceness);
+      } catch (error) {
+        logger.error('Audio', `Ignoring audio.ffmpegNiceness: ${(error as Error).message}`);
+        setEffectiveConfigValue('audio.ffmpegNiceness', 0);
+      }
+    }
+
+    // Fail fast instead of queueing up behind a systematically failing ffmpeg
+    const circuitBreaker = await retrieveConfigValue<{ threshold?: number; cooldownMs?: number }>('audio.circuitBreaker');
+    if (circuitBreaker && circuitBreaker.threshold) {
+      try {
+        setCircuitBreaker(circuitBreaker);
+      } catch (error) {
+        logger.error('Audio', `Ignoring audio.circuitBreaker: ${(error as Error).message}`);
+        setEffectiveConfigValue('audio.circuitBreaker', { ...circuitBreaker, threshold: 0 });
+      }
+    }
+
+    // Ingest policy checked against ffprobe before any processing
+    const inputRules = await retrieveConfigValue<InputRules>('audio.inputRules');
+    if (inputRules && Object.keys(inputRules).length > 0) {
+      try {
+        setInputRules(inputRules);
+      } catch (error) {
+        logger.error('Audio', `Ignoring audio.inputRules: ${(error as Error).message}`);
+        setEffectiveConfigValue('audio.inputRules', {});
+      }
+    }
+
+    // Provenance record of every processed file, for compliance
+    const auditLogPath = await retrieveConfigValue<string>('audio.auditLogPath');
+    if (auditLogPath) {
+      setAuditLogPath(auditLogPath);
+    }
+
+    // Run a command against each finished output, e.g. to copy it to a share
+    const postHook = await retrieveConfigValue<PostProcessHook>('audio.postHook');
+    if (postHook?.command) {
+      try {
+        setPostProcessHook(postHook);
+      } catch (error) {
+        logger.error('Audio', `Ignoring audio.postHook: ${(error as Error).message}`);
+        setEffectiveConfigValue('audio.postHook', { ...postHook, command: '' });
+      }
+    }
+
+    // These were applied once above; report them as applied, not as the file changes later
+    await pinStartupConfigValues([
+      'audio.allowedOutputFormats',
+      'audio.chainDir',
+      'audio.filterChainLimits',
+      'audio.maxDirectoryBytes',
+      'audio.debugLogging',
+      'audio.ffmpegNiceness',
+      'audio.circuitBreaker',
+      'audio.inputRules',
+      'audio.auditLogPath',
+      'audio.postHook',
+      'audio.warmUpOnStart',
+    ]);
+
     for await (const user of allUsers) {
       for await (const collectionName of collectionNames) {
         try {
@@ -1221,6 +1495,10 @@ export async function initializeApp(): Promise<InitializeResult> {
     // Note: Vector indexing will be handled by the embeddings module
     // Note: tiktoken doesn't require preloading
 
+    if (await retrieveConfigValue<boolean>('audio.warmUpOnStart')) {
+      await warmUpFfmpeg();
+    }
+
     const server = await createServer();
     await launchRest(server);
 
@@ -2,12 +2,103 @@
 import path from 'path';
 import fastifyStatic from '@fastify/static';
 import fs from 'fs/promises';
+import crypto from 'crypto';
+import { createReadStream } from 'fs';
 import type { FastifyInstance, FastifyRequest, FastifyReply } from 'fastify';
+import {
+  getPresetCatalog,
+  getPresetCategories,
+  validateFilterChain,
+  checkFilterChainLimits,
+  getOutputContentType,
+  getProcessingRates,
+  computeWaveformPeaks,
+  getCircuitBreakerState,
+  resolveContainedPath,
+} from '../../audio-processor.js';
+import { requireAuth } from './v1.js';
+import type { PresetCatalog, PresetCategory, FilterValidationResult, WaveformPeaks } from '../../audio-processor.js';
 
 /** Options for audio routes */
 export interface AudioRoutesOptions {
   outputDir?: string;
   addContentDisposition?: boolean;
+  /** Free space below which /health/disk reports unhealthy (default 1 GiB) */
+  minFreeBytes?: number;
+  /** Downloads, static files and waveforms allowed in flight at once; further requests get 429 (0 = unlimited) */
+  maxConcurrentDownloads?: number;
+}
+
+/** Disk usage report for the output directory's filesystem */
+interface DiskHealthResponse {
+  healthy: boolean;
+  free_bytes: number;
+  used_bytes: number;
+  total_bytes: number;
+  min_free_bytes: number;
+}
+
+/** Body for the filter validation route */
+interface ValidateBody {
+  filters: string[];
+}
+
+/** Content types for non-audio files written to the output directory */
+const CONTENT_TYPES: Record<string, string> = {
+  '.png': 'image/png',
+};
+
+/** Inclusive byte range requested with a Range header */
+interface ByteRange {
+  start: number;
+  end: number;
+}
+
+/**
+ * Parses a single-range Range header against a file size
+ * @param header - Range header value, e.g. "bytes=0-1023" or "bytes=-500"
+ * @param size - File size in bytes
+ * @returns The range, null when it can't be satisfied, or undefined to serve the whole file
+ */
+function parseByteRange(header: string, size: number): ByteRange | null | undefined {
+  const match = /^bytes=(\d*)-(\d*)$/.exec(header.trim());
+  // Multiple or malformed ranges fall back to the full file, which RFC 9110 allows
+  if (!match || (match[1] === '' && match[2] === '')) {
+    return undefined;
+  }
+
+  let start: number;
+  let end: number;
+  if (match[1] === '') {
+    // Suffix range: the last N bytes
+    start = Math.max(size - Number(match[2]), 0);
+    end = size - 1;
+  } else {
+    start = Number(match[1]);
+    end = match[2] === '' ? size - 1 : Math.min(Number(match[2]), size - 1);
+  }
+
+  return start <= end && start < size ? { start, end } : null;
+}
+
+/**
+ * Evaluates If-None-Match against the current entity-tag as RFC 9110 describes:
+ * "*" matches any representation, otherwise any tag in the list matches using
+ * weak comparison (W/ prefixes ignored)
+ * @param header - If-None-Match header value
+ * @param etag - Current entity-tag, quoted and optionally W/-prefixed
+ * @returns Whether the request's condition is false, i.e. 304 applies
+ */
+function matchesIfNoneMatch(header: string | undefined, etag: string): boolean {
+  if (!header) {
+    return false;
+  }
+  if (header.trim() === '*') {
+    return true;
+  }
+  const opaqueTag = etag.replace(/^W\//, '');
+  const tags = header.match(/(?:W\/)?"[^"]*"/g) || [];
+  return tags.some((tag) => tag.replace(/^W\//, '') === opaqueTag);
 }
 
 /** Params for filename routes */
@@ -15,6 +106,20 @@ interface FilenameParams {
   filename: string;
 }
 
+/** Query for the waveform route */
+interface WaveformQuery {
+  samples_per_peak?: string;
+  /** Number of peaks wanted, e.g. the pixel width of the player */
+  width?: string;
+}
+
+/** Waveform peak data for a processed file */
+interface WaveformResponse {
+  sample_rate: number;
+  samples_per_peak: number;
+  peaks: WaveformPeaks['peaks'];
+}
+
 /**
  * Audio routes plugin for serving audio files
  */
@@ -22,7 +127,29 @@ export async function audioRoutes(
   fastify: FastifyInstance,
   options: AudioRoutesOptions = {}
 ): Promise<void> {
-  const { outputDir = 'final', addContentDisposition = false } = options;
+  const {
+    outputDir = 'final',
+    addContentDisposition = false,
+    minFreeBytes = 1024 * 1024 * 1024,
+    maxConcurrentDownloads = 0,
+  } = options;
+
+  // Downloads currently streaming; released when each response closes
+  let activeDownloads = 0;
+
+  // Every route that reads output files takes a download slot, counted until
+  // the response finishes or the client goes away
+  const limitDownloads = async (_request: FastifyRequest, reply: FastifyReply): Promise<void | FastifyReply> => {
+    if (maxConcurrentDownloads > 0 && activeDownloads >= maxConcurrentDownloads) {
+      reply.header('Retry-After', '1');
+      return reply.code(429).send({ error: 'Too many concurrent downloads' });
+    }
+
+    activeDownloads++;
+    reply.raw.once('close', () => {
+      activeDownloads--;
+    });
+  };
 
   // Resolve the absolute path to the output directory
   const audioFilesPath = path.resolve(process.cwd(), outputDir);
@@ -34,14 +161,172 @@ export async function audioRoutes(
     return { status: 'Audio routes working' };
   });
 
+  // Disk space on the filesystem processed audio is written to
+  fastify.get(
+    '/health/disk',
+    async (_request: FastifyRequest, reply: FastifyReply): Promise<DiskHealthResponse | void> => {
+      try {
+        await fs.mkdir(audioFilesPath, { recursive: true });
+        const stats = await fs.statfs(audioFilesPath);
+        const totalBytes = stats.blocks * stats.bsize;
+        const freeBytes = stats.bavail * stats.bsize;
+        const healthy = freeBytes >= minFreeBytes;
+
+        return reply.code(healthy ? 200 : 503).send({
+          healthy,
+          free_bytes: freeBytes,
+          used_bytes: totalBytes - stats.bfree * stats.bsize,
+          total_bytes: totalBytes,
+          min_free_bytes: minFreeBytes,
+        });
+      } catch (error) {
+        console.error('Error checking disk space:', error);
+        return reply.code(500).send({ error: 'Error checking disk space' });
+      }
+    }
+  );
+
+  // Whether ffmpeg processing is accepting work; unhealthy while the circuit
+  // breaker is open after repeated failures
+  fastify.get('/health/ffmpeg', async (_request: FastifyRequest, reply: FastifyReply) => {
+    const breaker = getCircuitBreakerState();
+    if (breaker && breaker.open) {
+      reply.header('Retry-After', String(Math.ceil(breaker.retryAfterMs / 1000)));
+    }
+    return reply.code(breaker && breaker.open ? 503 : 200).send({
+      healthy: !(breaker && breaker.open),
+      circuit_breaker_enabled: breaker !== null,
+      consecutive_failures: breaker ? breaker.consecutiveFailures : 0,
+      retry_after_ms: breaker ? breaker.retryAfterMs : 0,
+    });
+  });
+
+  // Serialized preset catalog; it is fixed once startup has registered any
+  // configured profiles
+  let catalogJson: string | null = null;
+
+  // Built-in processing presets with per-stage descriptions and whether each is
+  // corrective or a creative effect, plus observed processing cost (ms per
+  // second of input) for presets that have run
+  fastify.get('/presets', async (request: FastifyRequest, reply: FastifyReply) => {
+    if (!catalogJson) {
+      const catalog: { presets: PresetCatalog; categories: Record<string, PresetCategory> } = {
+        presets: getPresetCatalog(),
+        categories: getPresetCategories(),
+      };
+      catalogJson = JSON.stringify(catalog);
+    }
+
+    // Rates move as jobs finish, so they are added fresh and the tag covers them
+    const rates = JSON.stringify(getProcessingRates());
+    const body = `${catalogJson.slice(0, -1)},"ms_per_input_second":${rates}}`;
+    // Weak: ?pretty=true reformats the same content
+    const etag = `W/"${crypto.createHash('sha1').update(body).digest('base64url')}"`;
+
+    reply.header('ETag', etag);
+    reply.header('Cache-Control', 'no-cache');
+    if (matchesIfNoneMatch(request.headers['if-none-match'], etag)) {
+      return reply.code(304).send();
+    }
+    return reply.type('application/json; charset=utf-8').send(body);
+  });
+
+  // Check a filter chain with ffmpeg without processing any audio. Signed-in
+  // users only, since it runs ffmpeg on client-supplied filters.
+  fastify.post<{ Body: ValidateBody }>(
+    '/validate',
+    { preHandler: requireAuth },
+    async (
+      request: FastifyRequest<{ Body: ValidateBody }>,
+      reply: FastifyReply
+    ): Promise<FilterValidationResult | void> => {
+      const { filters } = request.body || {};
+
+      if (
+        !Array.isArray(filters) ||
+        filters.length === 0 ||
+        !filters.every((filter) => typeof filter === 'string')
+      ) {
+        return reply.code(400).send({ error: 'filters must be a non-empty array of strings' });
+      }
+
+      const limitError = checkFilterChainLimits(filters);
+      if (limitError) {
+        return reply.code(400).send({ error: limitError });
+      }
+
+      return validateFilterChain(filters);
+    }
+  );
+
+  // Min/max peaks of a processed file, so players can draw it without decoding
+  fastify.get<{ Params: FilenameParams; Querystring: WaveformQuery }>(
+    '/waveform/:filename',
+    { onRequest: limitDownloads },
+    async (
+      request: FastifyRequest<{ Params: FilenameParams; Querystring: WaveformQuery }>,
+      reply: FastifyReply
+    ): Promise<WaveformResponse | void> => {
+      const { filename } = request.params;
+
+      // Prevent path traversal
+      if (
+        filename.includes('..') ||
+        filename.includes('/') ||
+        filename.includes('\\') ||
+        !resolveContainedPath(audioFilesPath, filename)
+      ) {
+        return reply.code(400).send({ error: 'Invalid filename' });
+      }
+
+      const samplesPerPeak = request.query.samples_per_peak ? Number(request.query.samples_per_peak) : undefined;
+      const width = request.query.width ? Number(request.query.width) : undefined;
+      const filePath = path.join(audioFilesPath, filename);
+
+      try {
+        await fs.access(filePath);
+      } catch {
+        return reply.code(404).send({ error: 'File not found' });
+      }
+
+      try {
+        const waveform = await computeWaveformPeaks(filePath, { samplesPerPeak, width });
+        return {
+          sample_rate: waveform.sampleRate,
+          samples_per_peak: waveform.samplesPerPeak,
+          peaks: waveform.peaks,
+        };
+      } catch (error) {
+        const message = (error as Error).message;
+        if (message.startsWith('samplesPerPeak') || message.startsWith('width')) {
+          return reply.code(400).send({ error: message });
+        }
+        if ((error as NodeJS.ErrnoException).code === 'WAVEFORM_TOO_LONG') {
+          return reply.code(422).send({ error: message });
+        }
+        if ((error as NodeJS.ErrnoException).code === 'RAW_PCM') {
+          return reply.code(415).send({ error: message });
+        }
+        console.error('Error computing waveform:', error);
+        return reply.code(500).send({ error: 'Error computing waveform' });
+      }
+    }
+  );
+
   // Direct file serving
   fastify.get<{ Params: FilenameParams }>(
     '/:filename',
+    { onRequest: limitDownloads },
     async (request: FastifyRequest<{ Params: FilenameParams }>, reply: FastifyReply) => {
       const { filename } = request.params;
 
       // Prevent path traversal
-      if (filename.includes('..') || filename.includes('/') || filename.includes('\\')) {
+      if (
+        filename.includes('..') ||
+        filename.includes('/') ||
+        filename.includes('\\') ||
+        !resolveContainedPath(audioFilesPath, filename)
+      ) {
         return reply.code(400).send({ error: 'Invalid filename' });
       }
 
@@ -49,18 +334,41 @@ export async function audioRoutes(
 
       try {
         // Verify file exists
-        await fs.access(filePath);
+        const stats = await fs.stat(filePath);
+        const lastModified = new Date(Math.floor(stats.mtimeMs / 1000) * 1000);
 
         // Set headers manually
-        reply.header('Content-Type', 'audio/wav');
+        const extension = path.extname(filename);
+        reply.header(
+          'Content-Type',
+          CONTENT_TYPES[extension] || getOutputContentType(extension) || 'audio/wav'
+        );
+        reply.header('Last-Modified', lastModified.toUTCString());
+        reply.header('Accept-Ranges', 'bytes');
 
         if (addContentDisposition) {
           reply.header('Content-Disposition', `attachment; filename="${filename}"`);
         }
 
-        // Read and send file directly
-        const fileContent = await fs.readFile(filePath);
-        return reply.send(fileContent);
+        const ifModifiedSince = Date.parse(request.headers['if-modified-since'] || '');
+        if (!request.headers.range && !Number.isNaN(ifModifiedSince) && lastModified.getTime() <= ifModifiedSince) {
+          return reply.code(304).send();
+        }
+
+        // Ranges let players seek and resume without downloading the whole file
+        const range = request.headers.range ? parseByteRange(request.headers.range, stats.size) : undefined;
+        if (range === null) {
+          reply.header('Content-Range', `bytes */${stats.size}`);
+          return reply.code(416).send();
+        }
+        if (range) {
+          reply.header('Content-Range', `bytes ${range.start}-${range.end}/${stats.size}`);
+          reply.header('Content-Length', range.end - range.start + 1);
+          return reply.code(206).send(createReadStream(filePath, range));
+        }
+
+        reply.header('Content-Length', stats.size);
+        return reply.send(createReadStream(filePath));
       } catch (error) {
         const err = error as NodeJS.ErrnoException;
         console.error('Error serving file:', err);
@@ -71,18 +379,28 @@ export async function audioRoutes(
     }
   );
 
-  // Register static file plugin as a separate handler
-  await fastify.register(fastifyStatic, {
-    root: audioFilesPath,
-    prefix: '/static',
-    decorateReply: false,
-    setHeaders: (res) => {
-      res.setHeader('Content-Type', 'audio/wav');
-      if (addContentDisposition) {
-        // Note: In the original code, 'req' was used but not available in this scope
-        // This is a known limitation - content disposition won't work properly for static files
-      }
-    },
+  // Register static file plugin as a separate handler, in its own scope so the
+  // download limit applies to it
+  await fastify.register(async (staticScope) => {
+    staticScope.addHook('onRequest', limitDownloads);
+    await staticScope.register(fastifyStatic, {
+      root: audioFilesPath,
+      prefix: '/static',
+      decorateReply: false,
+      // send() follows symlinks, so resolve them before anything leaves the directory
+      allowedPath: (pathName) => resolveContainedPath(audioFilesPath, pathName.replace(/^\/+/, '')) !== null,
+      setHeaders: (res, filePath) => {
+        const extension = path.extname(filePath).toLowerCase();
+        res.setHeader(
+          'Content-Type',
+          CONTENT_TYPES[extension] || getOutputContentType(extension) || 'audio/wav'
+        );
+        if (addContentDisposition) {
+          // Note: In the original code, 'req' was used but not available in this scope
+          // This is a known limitation - content disposition won't work properly for static files
+        }
+      },
+    });
   });
 
   // Add a delete route
@@ -95,7 +413,12 @@ export async function audioRoutes(
       const { filename } = request.params;
 
       // Prevent path traversal
-      if (filename.includes('..') || filename.includes('/') || filename.includes('\\')) {
+      if (
+        filename.includes('..') ||
+        filename.includes('/') ||
+        filename.includes('\\') ||
+        !resolveContainedPath(audioFilesPath, filename)
+      ) {
         return reply.code(400).send({ error: 'Invalid filename' });
       }
 
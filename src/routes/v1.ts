@@ -4,7 +4,7 @@ import {
   updateUserParameter,
   returnAuthObject,
 } from '../core/api-helper.js';
-import { retrieveConfigValue } from '../core/config.js';
+import { retrieveConfigValue, getRedactedConfig } from '../core/config.js';
 import { logger } from '../core/logger.js';
 import fastifyFormbody from '@fastify/formbody';
 import cors from '@fastify/cors';
@@ -40,7 +40,7 @@ import type {
   TwitchConnectQuery,
 } from '../types/routes.types.js';
 
-async function requireAuth(
+export async function requireAuth(
   request: FastifyRequest,
   reply: FastifyReply
 ): Promise<void | FastifyReply> {
@@ -89,6 +89,21 @@ async function requireAuth(
   }
 }
 
+/**
+ * Restricts a route to the operators listed in server.operators.
+ * Runs after requireAuth, which sets request.user.
+ */
+export async function requireOperator(
+  request: FastifyRequest,
+  reply: FastifyReply
+): Promise<void | FastifyReply> {
+  const operators = (await retrieveConfigValue<string[]>('server.operators')) || [];
+  const user = (request as AuthenticatedRequest).user;
+  if (!user || !operators.includes(user.user_id)) {
+    return reply.code(403).send({ error: 'Operator access required' });
+  }
+}
+
 export function createSessionToken(userId: string, _expiresIn = '7d'): string {
   // Create a token payload
   const payload: SessionPayload = {
@@ -141,6 +156,30 @@ export function verifySessionToken(token: string): SessionPayload | null {
   }
 }
 
+/** Voice uploads currently being received; released when each response closes */
+let activeVoiceUploads = 0;
+
+/**
+ * Rejects a voice upload with 429 when too many are already in flight.
+ * Runs on request after requireAuth, before the (large) body is read, so
+ * only signed-in users can hold a slot.
+ */
+async function limitVoiceUploads(
+  _request: FastifyRequest,
+  reply: FastifyReply
+): Promise<void | FastifyReply> {
+  const maxUploads = (await retrieveConfigValue<number>('server.maxConcurrentUploads')) || 0;
+  if (maxUploads > 0 && activeVoiceUploads >= maxUploads) {
+    reply.header('Retry-After', '1');
+    return reply.code(429).send({ error: 'Too many concurrent uploads' });
+  }
+
+  activeVoiceUploads++;
+  reply.raw.once('close', () => {
+    activeVoiceUploads--;
+  });
+}
+
 /**
  * Checks if the input contains a jailbreak attempt.
  */
@@ -244,10 +283,15 @@ async function routes(fastify: FastifyInstance, _options: unknown): Promise<void
     }
   );
 
+  // Effective configuration of this instance, with secrets redacted
+  fastify.get('/config', { preHandler: [requireAuth, requireOperator] }, async () => {
+    return getRedactedConfig();
+  });
+
   // Voice file upload endpoint
   fastify.post<{ Body: VoiceUploadBody }>(
     '/character/voice-upload',
-    { preHandler: requireAuth },
+    { onRequest: [requireAuth, limitVoiceUploads] },
     async (request, reply) => {
       try {
         const user = (request as AuthenticatedRequest).user;
@@ -303,6 +303,7 @@ export type {
 // Server types (Phase 11)
 export type {
   ServerOptions,
+  TlsVersion,
   ConnectionLimits,
   RateLimitData,
   ModelInfo,
@@ -9,6 +9,9 @@ import type { User } from './user.types.js';
 
 // ==================== SERVER OPTIONS ====================
 
+/** Minimum TLS versions that can be configured */
+export type TlsVersion = 'TLSv1.2' | 'TLSv1.3';
+
 /** Fastify server options */
 export interface ServerOptions {
   trustProxy: boolean;
@@ -27,6 +30,7 @@ export interface ServerOptions {
     allowHTTP1: boolean;
     key: Buffer;
     cert: Buffer;
+    minVersion?: TlsVersion;
   };
   http2?: boolean;
   http?: {
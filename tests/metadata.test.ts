@@ -0,0 +1,74 @@
+// tests/metadata.test.ts
+import { afterAll, beforeAll, describe, expect, test } from 'bun:test';
+import { execFileSync, spawnSync } from 'child_process';
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { processAudioDetailed } from '../audio-processor.js';
+
+// These run real ffmpeg; hosts without it skip them
+const hasFfmpeg = spawnSync('ffmpeg', ['-version']).status === 0 && spawnSync('ffprobe', ['-version']).status === 0;
+
+let workDir: string;
+let inputPath: string;
+
+/** Tags and whether an attached picture stream is present in a file */
+function probeMetadata(filePath: string): { title: string | undefined; hasCover: boolean } {
+  const output = execFileSync('ffprobe', ['-v', 'error', '-print_format', 'json', '-show_streams', '-show_format', filePath]);
+  const { streams = [], format = {} } = JSON.parse(output.toString());
+  const tags = Object.fromEntries(
+    Object.entries((format.tags || {}) as Record<string, string>).map(([key, value]) => [key.toLowerCase(), value])
+  );
+  return {
+    title: tags.title,
+    hasCover: streams.some(
+      (stream: { codec_type: string; disposition?: { attached_pic?: number } }) =>
+        stream.codec_type === 'video' && stream.disposition?.attached_pic === 1
+    ),
+  };
+}
+
+beforeAll(() => {
+  if (!hasFfmpeg) {
+    return;
+  }
+  workDir = fs.mkdtempSync(path.join(os.tmpdir(), 'enspira-metadata-'));
+  const coverPath = path.join(workDir, 'cover.png');
+  inputPath = path.join(workDir, 'tagged.mp3');
+  execFileSync('ffmpeg', ['-nostdin', '-v', 'error', '-f', 'lavfi', '-i', 'color=c=red:s=16x16', '-frames:v', '1', coverPath]);
+  execFileSync('ffmpeg', [
+    '-nostdin', '-v', 'error',
+    '-f', 'lavfi', '-i', 'sine=frequency=440:duration=4',
+    '-i', coverPath,
+    '-map', '0:a', '-map', '1:v',
+    '-c:a', 'libmp3lame', '-c:v', 'copy', '-disposition:v', 'attached_pic',
+    '-metadata', 'title=Fixture',
+    inputPath,
+  ]);
+});
+
+afterAll(() => {
+  if (workDir) {
+    fs.rmSync(workDir, { recursive: true, force: true });
+  }
+});
+
+describe.skipIf(!hasFfmpeg)('preserveMetadata', () => {
+  const cases = [
+    { name: 'keeps tags and cover art when set', preserveMetadata: true, title: 'Fixture', hasCover: true },
+    { name: 'strips tags and cover art when unset', preserveMetadata: false, title: undefined, hasCover: false },
+  ];
+
+  for (const { name, preserveMetadata, title, hasCover } of cases) {
+    test(name, async () => {
+      const outputDir = path.join(workDir, preserveMetadata ? 'kept' : 'stripped');
+      const { outputPath } = await processAudioDetailed(inputPath, {
+        outputFormat: 'mp3',
+        preserveMetadata,
+        outputDir,
+      });
+
+      expect(probeMetadata(path.join(outputDir, outputPath))).toEqual({ title, hasCover });
+    });
+  }
+});
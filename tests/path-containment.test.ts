@@ -0,0 +1,154 @@
+// tests/path-containment.test.ts
+import { afterAll, beforeAll, describe, expect, mock, test } from 'bun:test';
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import Fastify from 'fastify';
+import type { FastifyInstance } from 'fastify';
+import { processAudioDetailed, resolveContainedPath } from '../audio-processor.js';
+
+// v1.ts loads auth keys and config at import time; the audio routes only need requireAuth
+mock.module('../src/routes/v1.js', () => ({
+  requireAuth: async () => {},
+}));
+
+let workDir: string;
+let allowedDir: string;
+let outsideDir: string;
+
+beforeAll(() => {
+  workDir = fs.realpathSync(fs.mkdtempSync(path.join(os.tmpdir(), 'enspira-paths-')));
+  allowedDir = path.join(workDir, 'allowed');
+  outsideDir = path.join(workDir, 'outside');
+  fs.mkdirSync(path.join(allowedDir, 'nested'), { recursive: true });
+  fs.mkdirSync(outsideDir);
+
+  fs.writeFileSync(path.join(allowedDir, 'profile.json'), '[]');
+  fs.writeFileSync(path.join(allowedDir, 'nested', 'room.wav'), 'RIFF');
+  fs.writeFileSync(path.join(allowedDir, 'speech.wav'), 'RIFF');
+  fs.writeFileSync(path.join(outsideDir, 'secret.json'), '[]');
+
+  fs.symlinkSync(path.join(outsideDir, 'secret.json'), path.join(allowedDir, 'escape.json'));
+  fs.symlinkSync(outsideDir, path.join(allowedDir, 'escape-dir'));
+  fs.symlinkSync(path.join(allowedDir, 'profile.json'), path.join(allowedDir, 'alias.json'));
+});
+
+afterAll(() => {
+  fs.rmSync(workDir, { recursive: true, force: true });
+});
+
+describe('resolveContainedPath', () => {
+  const cases: Array<{ name: string; target: () => string; expected: () => string | null }> = [
+    { name: 'file in the directory', target: () => 'profile.json', expected: () => path.join(allowedDir, 'profile.json') },
+    { name: 'file in a subdirectory', target: () => 'nested/room.wav', expected: () => path.join(allowedDir, 'nested', 'room.wav') },
+    { name: 'missing file in the directory', target: () => 'missing.json', expected: () => path.join(allowedDir, 'missing.json') },
+    { name: '.. that stays inside', target: () => 'nested/../profile.json', expected: () => path.join(allowedDir, 'profile.json') },
+    { name: 'symlink to a file inside', target: () => 'alias.json', expected: () => path.join(allowedDir, 'profile.json') },
+    { name: '.. out of the directory', target: () => '../outside/secret.json', expected: () => null },
+    { name: 'repeated ..', target: () => '../../../../etc/passwd', expected: () => null },
+    { name: 'the directory itself', target: () => '.', expected: () => null },
+    { name: 'absolute path outside', target: () => path.join(outsideDir, 'secret.json'), expected: () => null },
+    { name: 'absolute system path', target: () => '/etc/passwd', expected: () => null },
+    { name: 'sibling with a shared prefix', target: () => '../allowed-other/file.json', expected: () => null },
+    { name: 'symlink to a file outside', target: () => 'escape.json', expected: () => null },
+    { name: 'file under a symlinked directory outside', target: () => 'escape-dir/secret.json', expected: () => null },
+  ];
+
+  for (const { name, target, expected } of cases) {
+    test(name, () => {
+      expect(resolveContainedPath(allowedDir, target())).toBe(expected());
+    });
+  }
+});
+
+describe('processAudioDetailed EQ profile containment', () => {
+  const cases = [
+    { name: '..', eqFile: '../outside/secret.json' },
+    { name: 'absolute path', eqFile: '/etc/passwd' },
+    { name: 'symlink escape', eqFile: 'escape.json' },
+  ];
+
+  for (const { name, eqFile } of cases) {
+    test(`rejects ${name}`, async () => {
+      await expect(
+        processAudioDetailed(path.join(allowedDir, 'speech.wav'), {
+          eqFile,
+          eqDir: allowedDir,
+          outputDir: path.join(workDir, 'final'),
+          preconvertCodecs: [],
+        })
+      ).rejects.toThrow('EQ profile must be inside');
+    });
+  }
+});
+
+describe('processAudioDetailed reference track containment', () => {
+  const cases = [
+    { name: '..', referencePath: '../outside/secret.json' },
+    { name: 'absolute path', referencePath: '/etc/passwd' },
+    { name: 'symlink escape', referencePath: 'escape.json' },
+  ];
+
+  for (const { name, referencePath } of cases) {
+    test(`rejects ${name}`, async () => {
+      await expect(
+        processAudioDetailed(path.join(allowedDir, 'speech.wav'), {
+          referencePath,
+          referenceDir: allowedDir,
+          outputDir: path.join(workDir, 'final'),
+          preconvertCodecs: [],
+        })
+      ).rejects.toThrow('Reference track must be inside');
+    });
+  }
+});
+
+describe('audio file routes', () => {
+  let app: FastifyInstance;
+
+  beforeAll(async () => {
+    const { audioRoutes } = await import('../src/routes/audio.js');
+    app = Fastify();
+    await app.register(audioRoutes, { outputDir: allowedDir });
+    await app.ready();
+  });
+
+  afterAll(async () => {
+    await app.close();
+  });
+
+  const cases = [
+    { name: 'encoded ..', filename: '..%2Foutside%2Fsecret.json', status: 400 },
+    { name: 'encoded absolute path', filename: '%2Fetc%2Fpasswd', status: 400 },
+    { name: 'encoded backslash', filename: '..%5Csecret.json', status: 400 },
+    { name: 'symlink escape', filename: 'escape.json', status: 400 },
+    { name: 'file in the directory', filename: 'speech.wav', status: 200 },
+    { name: 'missing file in the directory', filename: 'missing.wav', status: 404 },
+  ];
+
+  for (const { name, filename, status } of cases) {
+    test(`download of ${name} returns ${status}`, async () => {
+      const response = await app.inject({ method: 'GET', url: `/${filename}` });
+      expect(response.statusCode).toBe(status);
+    });
+  }
+
+  const staticCases = [
+    { name: 'symlink escape', filename: 'escape.json', status: 404 },
+    { name: 'file under a symlinked directory outside', filename: 'escape-dir/secret.json', status: 404 },
+    { name: 'file in the directory', filename: 'speech.wav', status: 200 },
+  ];
+
+  for (const { name, filename, status } of staticCases) {
+    test(`static download of ${name} returns ${status}`, async () => {
+      const response = await app.inject({ method: 'GET', url: `/static/${filename}` });
+      expect(response.statusCode).toBe(status);
+    });
+  }
+
+  test('delete of a symlink escape returns 400 and keeps the target', async () => {
+    const response = await app.inject({ method: 'DELETE', url: '/escape.json' });
+    expect(response.statusCode).toBe(400);
+    expect(fs.existsSync(path.join(outsideDir, 'secret.json'))).toBe(true);
+  });
+});